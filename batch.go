@@ -0,0 +1,255 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// WithBatching splits BulkMail recipient lists longer than size into chunks of at most size recipients, and
+// sends up to workers chunks concurrently. Each chunk is a separate API call carrying only its own
+// recipients' merge vars and metadata. Without this option every recipient goes in a single call.
+func WithBatching(size, workers int) MandrillOption {
+	return func(m *mandrill) error {
+		if size < 1 {
+			return errors.New("WithBatching: size must be at least 1")
+		}
+		if workers < 1 {
+			workers = 1
+		}
+		m.batchSize = size
+		m.batchWorkers = workers
+		return nil
+	}
+}
+
+// ChunkError describes a chunk of a batched BulkMail that failed. The chunk's recipients were at [Start:End]
+// of the list that was sent, and Recipients holds those that weren't sent. That list omits any suppressed
+// recipients, so use Recipients to retry the chunk.
+type ChunkError struct {
+	Chunk      int
+	Start      int
+	End        int
+	Recipients []MailRecipient
+	Err        error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d (recipients %d-%d) failed: %s", e.Chunk, e.Start, e.End-1, e.Err.Error())
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by a batched BulkMail when one or more chunks fail. The responses returned with it
+// still cover every recipient; those that failed chunks didn't send have MAIL_MESSAGE_UNKNOWN status and the
+// chunk's error.
+type BatchError struct {
+	Chunks []*ChunkError
+}
+
+func (e *BatchError) Error() string {
+
+	msgs := make([]string, len(e.Chunks), len(e.Chunks))
+	for i, c := range e.Chunks {
+		msgs[i] = c.Error()
+	}
+
+	return fmt.Sprintf("BulkMail: %d chunk(s) failed: %s", len(e.Chunks), strings.Join(msgs, `; `))
+}
+
+// Unwrap exposes the chunk errors to errors.Is and errors.As, e.g. to find an *APIError
+func (e *BatchError) Unwrap() []error {
+
+	errs := make([]error, len(e.Chunks), len(e.Chunks))
+	for i, c := range e.Chunks {
+		errs[i] = c
+	}
+
+	return errs
+}
+
+// unsent returns the recipients in the failed chunks
+func (e *BatchError) unsent() []MailRecipient {
+
+	var recipients []MailRecipient
+	for _, c := range e.Chunks {
+		recipients = append(recipients, c.Recipients...)
+	}
+
+	return recipients
+}
+
+// partialError is implemented by errors that report which recipients of a send were not sent. The responses
+// returned with them give those recipients MAIL_MESSAGE_UNKNOWN status and the error, and cover the others as
+// usual. When they are nested, the outermost one describes the whole send.
+type partialError interface {
+	error
+	unsent() []MailRecipient
+}
+
+// UnsentError is returned when a send stopped part way, e.g. when one of the messages of a MERGE_MODE_LOCAL
+// BulkMail failed after the earlier ones were sent. The responses returned with it cover the recipients that
+// were sent as usual; those in Recipients have MAIL_MESSAGE_UNKNOWN status and the error.
+type UnsentError struct {
+	// Recipients were not sent, and can be retried
+	Recipients []MailRecipient
+	Err        error
+}
+
+func (e *UnsentError) Error() string {
+	return fmt.Sprintf("%d recipient(s) not sent: %s", len(e.Recipients), e.Err.Error())
+}
+
+func (e *UnsentError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnsentError) unsent() []MailRecipient {
+	return e.Recipients
+}
+
+// unsentResponses returns MAIL_MESSAGE_UNKNOWN responses for recipients that weren't sent because of err
+func unsentResponses(recipients []MailRecipient, err error) []MailRecipientResponse {
+
+	resp := make([]MailRecipientResponse, len(recipients), len(recipients))
+	for i, r := range recipients {
+		resp[i] = MailRecipientResponse{
+			Email:  r.Email,
+			Status: MAIL_MESSAGE_UNKNOWN,
+			Error:  err.Error(),
+		}
+	}
+
+	return resp
+}
+
+// withDecided adds the responses for recipients that were decided without being sent, e.g. skipped as
+// rejected, to those from sending the rest, and puts them all in the order of recipients. If the send failed
+// outright, the decided responses are still returned, and the sent recipients are reported in an
+// *UnsentError.
+func withDecided(recipients, sent []MailRecipient, resp, decided []MailRecipientResponse, err error) ([]MailRecipientResponse, error) {
+
+	if len(decided) == 0 {
+		return resp, err
+	}
+
+	var partialErr partialError
+	if err != nil && !errors.As(err, &partialErr) {
+		resp = unsentResponses(sent, err)
+		err = &UnsentError{Recipients: sent, Err: err}
+	}
+
+	return inRecipientOrder(recipients, append(resp, decided...)), err
+}
+
+// inRecipientOrder puts resp in the order of recipients, matching them by email. Responses for emails that
+// aren't among recipients are kept at the end.
+func inRecipientOrder(recipients []MailRecipient, resp []MailRecipientResponse) []MailRecipientResponse {
+
+	byEmail := make(map[string][]int, len(resp))
+	for i, r := range resp {
+		email := strings.ToLower(r.Email)
+		byEmail[email] = append(byEmail[email], i)
+	}
+
+	var (
+		ordered = make([]MailRecipientResponse, 0, len(resp))
+		used    = make([]bool, len(resp), len(resp))
+	)
+	for _, r := range recipients {
+		email := strings.ToLower(r.Email)
+		if matches := byEmail[email]; len(matches) > 0 {
+			ordered = append(ordered, resp[matches[0]])
+			used[matches[0]] = true
+			byEmail[email] = matches[1:]
+		}
+	}
+	for i, r := range resp {
+		if !used[i] {
+			ordered = append(ordered, r)
+		}
+	}
+
+	return ordered
+}
+
+// bulkMailBatched sends recipients in chunks using a bounded pool of workers, and reassembles the responses
+// in the order of the recipients
+func (m *mandrill) bulkMailBatched(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	var (
+		chunks    = (len(recipients) + m.batchSize - 1) / m.batchSize
+		responses = make([][]MailRecipientResponse, chunks, chunks)
+		errs      = make([]error, chunks, chunks)
+		work      = make(chan int)
+		wg        sync.WaitGroup
+	)
+
+	for w := 0; w < m.batchWorkers && w < chunks; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				start, end := m.chunkBounds(i, len(recipients))
+				responses[i], errs[i] = m.bulkMailChunk(ctx, recipients[start:end], message, params)
+			}
+		}()
+	}
+
+	// once ctx is done, remaining chunks are failed without being sent
+	for i := 0; i < chunks; i++ {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var (
+		resp     = make([]MailRecipientResponse, 0, len(recipients))
+		batchErr = new(BatchError)
+	)
+	for i := 0; i < chunks; i++ {
+
+		if errs[i] == nil {
+			resp = append(resp, responses[i]...)
+			continue
+		}
+
+		// a chunk that was partly sent keeps its responses, and only its unsent recipients are retried
+		start, end := m.chunkBounds(i, len(recipients))
+		chunkErr := &ChunkError{Chunk: i, Start: start, End: end, Recipients: recipients[start:end], Err: errs[i]}
+		var partialErr partialError
+		if errors.As(errs[i], &partialErr) {
+			chunkErr.Recipients = partialErr.unsent()
+			resp = append(resp, responses[i]...)
+		} else {
+			resp = append(resp, unsentResponses(recipients[start:end], errs[i])...)
+		}
+		batchErr.Chunks = append(batchErr.Chunks, chunkErr)
+	}
+
+	if len(batchErr.Chunks) > 0 {
+		return resp, batchErr
+	}
+
+	return resp, nil
+}
+
+// chunkBounds returns the range of recipient indexes in chunk i
+func (m *mandrill) chunkBounds(i, total int) (int, int) {
+
+	start := i * m.batchSize
+	end := start + m.batchSize
+	if end > total {
+		end = total
+	}
+
+	return start, end
+}
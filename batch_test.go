@@ -0,0 +1,193 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMandrill_BulkMailBatched(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		if len(p.Message.To) > 3 {
+			t.Errorf("Chunk of %d recipients exceeds the batch size", len(p.Message.To))
+		}
+
+		resp := make([]mandrillRecipientResponse, len(p.Message.To))
+		for i, v := range p.Message.To {
+			if v.Email == `fail@example.com` {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"status":"error","code":-2,"name":"ValidationError","message":"bad chunk"}`))
+				return
+			}
+			if len(p.Message.RecipientMetadata) != len(p.Message.To) || p.Message.RecipientMetadata[i].Rcpt != v.Email {
+				t.Errorf("Recipient metadata is not aligned with chunk for %s", v.Email)
+			}
+			resp[i] = mandrillRecipientResponse{Email: v.Email, Status: MAIL_MESSAGE_SENT}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}, WithBatching(3, 2))
+
+	recipients := make([]MailRecipient, 10)
+	for i := range recipients {
+		recipients[i] = MailRecipient{
+			Email:         fmt.Sprintf("user%d@example.com", i),
+			RecipientType: MAIL_TO,
+			Metadata:      map[string]string{`index`: fmt.Sprint(i)},
+		}
+	}
+	recipients[4].Email = `fail@example.com`
+
+	message := newTestMessage()
+
+	resp, err := m.BulkMail(recipients, message, &SendParams{})
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Chunks) != 1 || batchErr.Chunks[0].Start != 3 || batchErr.Chunks[0].End != 6 {
+		t.Fatalf("Expected chunk 1 to fail, got %v", err)
+	}
+
+	if !IsValidationError(err) {
+		t.Error("The chunk's APIError should be reachable through the BatchError")
+	}
+
+	if requests != 4 || len(resp) != len(recipients) {
+		t.Fatalf("Expected 4 requests and %d responses, got %d and %d", len(recipients), requests, len(resp))
+	}
+
+	for i, v := range resp {
+		if v.Email != recipients[i].Email {
+			t.Errorf("Response %d is for %s, expected %s", i, v.Email, recipients[i].Email)
+		}
+
+		failed := i >= 3 && i < 6
+		if failed != (v.Status == MAIL_MESSAGE_UNKNOWN) {
+			t.Errorf("Unexpected status %s for recipient %d", v.Status, i)
+		}
+	}
+}
+
+// batchTestRecipients returns n recipients with distinct emails
+func batchTestRecipients(n int) []MailRecipient {
+
+	recipients := make([]MailRecipient, n)
+	for i := range recipients {
+		recipients[i] = MailRecipient{Email: fmt.Sprintf("user%d@example.com", i), RecipientType: MAIL_TO}
+	}
+
+	return recipients
+}
+
+// batchTestResponse answers a send with a sent status for each recipient
+func batchTestResponse(w http.ResponseWriter, r *http.Request) {
+
+	var p mandrillParams
+	json.NewDecoder(r.Body).Decode(&p)
+
+	resp := make([]mandrillRecipientResponse, len(p.Message.To))
+	for i, v := range p.Message.To {
+		resp[i] = mandrillRecipientResponse{Email: v.Email, Status: MAIL_MESSAGE_SENT}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestMandrill_BulkMailBatchedCancelled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the first chunk cancels the send while it is in flight, so the single worker never sends another
+	var requests int32
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		cancel()
+		batchTestResponse(w, r)
+	}, WithBatching(1, 1))
+
+	recipients := batchTestRecipients(4)
+	resp, err := m.BulkMailContext(ctx, recipients, newTestMessage(), &SendParams{})
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Expected 1 request before the send was cancelled, got %d", n)
+	}
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a BatchError for the cancelled chunks, got %v", err)
+	}
+
+	// whether the first chunk's response arrived before the cancellation is a race, but the rest can't be sent
+	unsent := map[int]bool{}
+	for _, c := range batchErr.Chunks {
+		if len(c.Recipients) != 1 || c.Recipients[0].Email != recipients[c.Chunk].Email {
+			t.Errorf("Chunk %d should report its recipient unsent, got %+v", c.Chunk, c.Recipients)
+		}
+		unsent[c.Chunk] = true
+	}
+	if !unsent[1] || !unsent[2] || !unsent[3] {
+		t.Fatalf("Expected chunks 1 to 3 to be reported unsent, got %v", err)
+	}
+
+	if len(resp) != len(recipients) {
+		t.Fatalf("Expected %d responses, got %d", len(recipients), len(resp))
+	}
+	for i, v := range resp[1:] {
+		if v.Email != recipients[i+1].Email || v.Status != MAIL_MESSAGE_UNKNOWN {
+			t.Errorf("Unexpected response for unsent recipient %d : %+v", i+1, v)
+		}
+	}
+}
+
+func TestMandrill_BulkMailBatchedWorkers(t *testing.T) {
+
+	var (
+		requests int32
+		inFlight int32
+		mu       sync.Mutex
+		peak     int32
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		mu.Lock()
+		if n > peak {
+			peak = n
+		}
+		mu.Unlock()
+
+		// hold the request so that the other workers' chunks overlap it
+		time.Sleep(20 * time.Millisecond)
+		batchTestResponse(w, r)
+	}, WithBatching(1, 3))
+
+	recipients := batchTestRecipients(9)
+	if _, err := m.BulkMail(recipients, newTestMessage(), &SendParams{}); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if n := atomic.LoadInt32(&requests); n != 9 || peak != 3 {
+		t.Errorf("Expected 9 requests with up to 3 in flight, got %d with a peak of %d", n, peak)
+	}
+}
@@ -0,0 +1,138 @@
+package mandrillmail
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error names returned by the Mandrill API in the "name" field of an error response
+// @see https://mailchimp.com/developer/transactional/docs/fundamentals/#errors
+const (
+	MANDRILL_ERROR_INVALID_KEY         = `Invalid_Key`
+	MANDRILL_ERROR_VALIDATION          = `ValidationError`
+	MANDRILL_ERROR_UNKNOWN_TEMPLATE    = `Unknown_Template`
+	MANDRILL_ERROR_PAYMENT_REQUIRED    = `PaymentRequired`
+	MANDRILL_ERROR_UNKNOWN_SUBACCOUNT  = `Unknown_Subaccount`
+	MANDRILL_ERROR_UNKNOWN_MESSAGE     = `Unknown_Message`
+	MANDRILL_ERROR_SERVICE_UNAVAILABLE = `ServiceUnavailable`
+	MANDRILL_ERROR_GENERAL             = `GeneralError`
+	MANDRILL_ERROR_TOO_MANY_REQUESTS   = `Too_Many_Requests`
+)
+
+// APIError is returned when Mandrill responds to a call with a non-2xx status. Use errors.As, or one of the
+// Is* helpers below, to inspect it.
+type APIError struct {
+	// HTTPStatus is the HTTP status code of the response
+	HTTPStatus int
+	// Status, Code, Name and Message are copied from the Mandrill error body. Status is normally "error".
+	Status  string
+	Code    int
+	Name    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+
+	if e.Name == `` {
+		return fmt.Sprintf("mandrill: request failed with HTTP status %d: %s", e.HTTPStatus, e.Message)
+	}
+
+	return fmt.Sprintf("mandrill: %s (code %d, HTTP status %d): %s", e.Name, e.Code, e.HTTPStatus, e.Message)
+}
+
+// newAPIError builds an APIError from a decoded Mandrill error body
+func newAPIError(httpStatus int, e *mandrillErrorResponse) *APIError {
+
+	return &APIError{
+		HTTPStatus: httpStatus,
+		Status:     e.Status,
+		Code:       e.Code,
+		Name:       e.Name,
+		Message:    e.Message,
+	}
+}
+
+// validationError reports a message or recipient that can't be sent as it stands, so there is no point in
+// trying again
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+// isAPIErrorNamed reports whether err wraps an APIError with the supplied Mandrill error name
+func isAPIErrorNamed(err error, name string) bool {
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Name == name
+	}
+
+	return false
+}
+
+// IsRateLimited reports whether Mandrill, or the client-side RateLimiter, refused the call because too many
+// requests were made
+func IsRateLimited(err error) bool {
+
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus == http.StatusTooManyRequests || apiErr.Name == MANDRILL_ERROR_TOO_MANY_REQUESTS
+	}
+
+	return false
+}
+
+// isRetryableError reports whether an API error is transient. Mandrill reports most errors, including
+// permanent ones like Invalid_Key, with a 500 status, so only unnamed 5xx responses (e.g. from a proxy) and
+// errors Mandrill names as transient are retried.
+func isRetryableError(err error) bool {
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	if IsRateLimited(err) {
+		return true
+	}
+
+	switch apiErr.Name {
+	case ``, MANDRILL_ERROR_SERVICE_UNAVAILABLE, MANDRILL_ERROR_GENERAL:
+		return apiErr.HTTPStatus >= 500
+	}
+
+	return false
+}
+
+// IsInvalidKey reports whether err was caused by an invalid API key
+func IsInvalidKey(err error) bool {
+	return isAPIErrorNamed(err, MANDRILL_ERROR_INVALID_KEY)
+}
+
+// IsValidationError reports whether Mandrill rejected the request parameters
+func IsValidationError(err error) bool {
+	return isAPIErrorNamed(err, MANDRILL_ERROR_VALIDATION)
+}
+
+// IsUnknownTemplate reports whether the request referenced a template that does not exist
+func IsUnknownTemplate(err error) bool {
+	return isAPIErrorNamed(err, MANDRILL_ERROR_UNKNOWN_TEMPLATE)
+}
+
+// IsPaymentRequired reports whether the account must be upgraded or topped up before the call can succeed
+func IsPaymentRequired(err error) bool {
+	return isAPIErrorNamed(err, MANDRILL_ERROR_PAYMENT_REQUIRED)
+}
+
+// IsUnknownMessage reports whether the message id doesn't exist, or is no longer known to Mandrill
+func IsUnknownMessage(err error) bool {
+	return isAPIErrorNamed(err, MANDRILL_ERROR_UNKNOWN_MESSAGE)
+}
@@ -0,0 +1,410 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is reported for a FailoverMailer backend that was skipped because it has failed repeatedly
+var ErrCircuitOpen = errors.New("circuit open")
+
+// FailoverBackend is one of the Mailers behind a FailoverMailer
+type FailoverBackend struct {
+	// Name identifies the backend in MailRecipientResponse.Provider and in errors, e.g. "mandrill" or "smtp"
+	Name   string
+	Mailer Mailer
+}
+
+// FailoverConfig configures a FailoverMailer
+type FailoverConfig struct {
+	// FailureThreshold is the number of consecutive failures after which a backend's circuit opens, and it is
+	// skipped. Defaults to 5.
+	FailureThreshold int
+	// Cooldown is how long an open circuit stays open. After that, one send is let through to test the
+	// backend: if it succeeds the circuit closes, and if not it opens again. Defaults to 30s.
+	Cooldown time.Duration
+	// Logger receives failover activity. Defaults to discarding it.
+	Logger Logger
+}
+
+// ProviderError is the error from one backend of a FailoverMailer or SplitMailer
+type ProviderError struct {
+	Provider string
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Provider + `: ` + e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// FailoverError is returned when some recipients couldn't be sent through any backend. The responses returned
+// with it still cover every recipient; those in Recipients have MAIL_MESSAGE_UNKNOWN status and the last error.
+type FailoverError struct {
+	// Recipients were not sent, and can be retried
+	Recipients []MailRecipient
+	// Errors holds the error from each backend that was tried or skipped, in order
+	Errors []*ProviderError
+}
+
+func (e *FailoverError) Error() string {
+
+	msgs := make([]string, len(e.Errors), len(e.Errors))
+	for i, pe := range e.Errors {
+		msgs[i] = pe.Error()
+	}
+
+	return fmt.Sprintf("Failover: %d recipient(s) not sent: %s", len(e.Recipients), strings.Join(msgs, `; `))
+}
+
+// Unwrap exposes the backend errors to errors.Is and errors.As, e.g. to find an *APIError
+func (e *FailoverError) Unwrap() []error {
+	return unwrapProviderErrors(e.Errors)
+}
+
+func (e *FailoverError) unsent() []MailRecipient {
+	return e.Recipients
+}
+
+// unwrapProviderErrors converts provider errors to plain errors for Unwrap
+func unwrapProviderErrors(pes []*ProviderError) []error {
+
+	errs := make([]error, len(pes), len(pes))
+	for i, pe := range pes {
+		errs[i] = pe
+	}
+
+	return errs
+}
+
+// FailoverMailer is a Mailer that sends through several backends in order, e.g. Mandrill first and then an
+// SMTP relay. When a backend returns an error, the recipients it didn't send go to the next backend. Every
+// response a backend returns is final, so recipients it rejected are never sent again through another one.
+// Each backend has a circuit breaker, so one that keeps failing is skipped for a while. A FailoverMailer is
+// safe for concurrent use.
+//
+// Cancelling the context, and a *SuppressionError, stop the send rather than failing over. If earlier
+// backends had already sent some recipients, the rest are reported in a *FailoverError.
+type FailoverMailer struct {
+	backends []*failoverBackend
+	config   FailoverConfig
+	now      func() time.Time
+
+	mu sync.Mutex
+}
+
+// failoverBackend is a backend with its circuit breaker state, which is guarded by FailoverMailer.mu
+type failoverBackend struct {
+	FailoverBackend
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var _ MailerContext = new(FailoverMailer)
+
+// NewFailoverMailer creates a FailoverMailer that tries backends in the order given
+func NewFailoverMailer(backends []FailoverBackend, config FailoverConfig) (*FailoverMailer, error) {
+
+	if len(backends) == 0 {
+		return nil, errors.New("at least one backend is required")
+	}
+
+	f := &FailoverMailer{now: time.Now}
+	for _, b := range backends {
+		if b.Mailer == nil {
+			return nil, errors.New("backend " + b.Name + " has no Mailer")
+		}
+		f.backends = append(f.backends, &failoverBackend{FailoverBackend: b})
+	}
+
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 5
+	}
+
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+
+	if config.Logger == nil {
+		config.Logger = nopLogger{}
+	}
+
+	f.config = config
+
+	return f, nil
+}
+
+// BulkMail sends message to recipients through the first backend that succeeds. Responses are in the order
+// of recipients, whichever backend sent them.
+func (f *FailoverMailer) BulkMail(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	return f.BulkMailContext(context.Background(), recipients, message, params)
+}
+
+// BulkMailContext is BulkMail with a context that bounds the send across all backends
+func (f *FailoverMailer) BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	// invalid input would fail on every backend, so it isn't counted against them
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if message == nil {
+		return nil, errors.New("BulkMail: Must specify message;")
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, err
+	}
+
+	if params == nil {
+		params = new(SendParams)
+	}
+
+	var (
+		resp    []MailRecipientResponse
+		pending = recipients
+		errs    []*ProviderError
+	)
+	for _, b := range f.backends {
+
+		if !f.allow(b) {
+			errs = append(errs, &ProviderError{Provider: b.Name, Err: ErrCircuitOpen})
+			continue
+		}
+
+		r, err := bulkMailContext(ctx, b.Mailer, pending, message, params)
+		f.record(ctx, b, err)
+		if err == nil {
+			resp = append(resp, withProvider(r, b.Name)...)
+			return inRecipientOrder(recipients, resp), nil
+		}
+
+		// keep the responses for recipients the backend sent or otherwise decided on, and pass the rest on
+		sent, unsent := splitUnsent(r, pending, err)
+		resp = append(resp, withProvider(sent, b.Name)...)
+		pending = unsent
+		errs = append(errs, &ProviderError{Provider: b.Name, Err: err})
+
+		if !f.canFailover(ctx, err) {
+			// with nothing decided yet, the error describes the whole send
+			if len(resp) == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		f.config.Logger.Error(`mandrill: failing over`, `provider`, b.Name, `recipients`, len(pending), `error`, err)
+	}
+
+	resp = append(resp, unsentResponses(pending, errs[len(errs)-1].Err)...)
+
+	return inRecipientOrder(recipients, resp), &FailoverError{Recipients: pending, Errors: errs}
+}
+
+// TemplateMail sends a templated email through the first backend that succeeds
+func (f *FailoverMailer) TemplateMail(toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+	return f.TemplateMailContext(context.Background(), toEmail, subject, template, vars)
+}
+
+// TemplateMailContext is TemplateMail with a context that bounds the send across all backends
+func (f *FailoverMailer) TemplateMailContext(ctx context.Context, toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+
+	if strings.TrimSpace(toEmail) == `` {
+		return nil, errors.New("TemplateMail: Must specify destination email address;")
+	}
+	if template == nil {
+		return nil, errors.New("TemplateMail: Must specify template;")
+	}
+
+	return f.single(ctx, toEmail, func(m Mailer) (*MailRecipientResponse, error) {
+		if mc, ok := m.(MailerContext); ok {
+			return mc.TemplateMailContext(ctx, toEmail, subject, template, vars)
+		}
+		return m.TemplateMail(toEmail, subject, template, vars)
+	})
+}
+
+// SimpleMail sends a very simple email through the first backend that succeeds
+func (f *FailoverMailer) SimpleMail(from, to, subject, body string) (*MailRecipientResponse, error) {
+	return f.SimpleMailContext(context.Background(), from, to, subject, body)
+}
+
+// SimpleMailContext is SimpleMail with a context that bounds the send across all backends
+func (f *FailoverMailer) SimpleMailContext(ctx context.Context, from, to, subject, body string) (*MailRecipientResponse, error) {
+
+	if strings.TrimSpace(from) == `` {
+		return nil, errors.New("SimpleMail: Must specify source email address;")
+	}
+	if strings.TrimSpace(to) == `` {
+		return nil, errors.New("SimpleMail: Must specify destination email address;")
+	}
+	if strings.TrimSpace(subject) == `` {
+		return nil, errors.New("SimpleMail: Must specify subject;")
+	}
+
+	return f.single(ctx, to, func(m Mailer) (*MailRecipientResponse, error) {
+		if mc, ok := m.(MailerContext); ok {
+			return mc.SimpleMailContext(ctx, from, to, subject, body)
+		}
+		return m.SimpleMail(from, to, subject, body)
+	})
+}
+
+// single sends to one recipient through the first backend that succeeds
+func (f *FailoverMailer) single(ctx context.Context, email string, send func(m Mailer) (*MailRecipientResponse, error)) (*MailRecipientResponse, error) {
+
+	var errs []*ProviderError
+	for _, b := range f.backends {
+
+		if !f.allow(b) {
+			errs = append(errs, &ProviderError{Provider: b.Name, Err: ErrCircuitOpen})
+			continue
+		}
+
+		r, err := send(b.Mailer)
+		f.record(ctx, b, err)
+		if err == nil {
+			return withProviderSingle(r, b.Name), nil
+		}
+
+		if !f.canFailover(ctx, err) {
+			return nil, err
+		}
+
+		errs = append(errs, &ProviderError{Provider: b.Name, Err: err})
+		f.config.Logger.Error(`mandrill: failing over`, `provider`, b.Name, `recipients`, 1, `error`, err)
+	}
+
+	return nil, &FailoverError{Recipients: []MailRecipient{{Email: email, RecipientType: MAIL_TO}}, Errors: errs}
+}
+
+// canFailover reports whether the send should move on to the next backend after err
+func (f *FailoverMailer) canFailover(ctx context.Context, err error) bool {
+
+	// a backend's own timeout is a failure, but the caller giving up is not
+	if ctx.Err() != nil {
+		return false
+	}
+
+	// suppression is a decision about the recipients, which another backend must not override
+	var suppressionErr *SuppressionError
+	return !errors.As(err, &suppressionErr)
+}
+
+// allow reports whether b may be tried. Once an open circuit's cooldown has passed, a single caller is let
+// through to test the backend.
+func (f *FailoverMailer) allow(b *failoverBackend) bool {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if b.failures < f.config.FailureThreshold {
+		return true
+	}
+
+	if b.probing || f.now().Before(b.openUntil) {
+		return false
+	}
+
+	b.probing = true
+
+	return true
+}
+
+// record updates b's circuit breaker with the outcome of a send
+func (f *FailoverMailer) record(ctx context.Context, b *failoverBackend, err error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b.probing = false
+
+	if err == nil {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	if !f.canFailover(ctx, err) {
+		return
+	}
+
+	b.failures++
+	if b.failures >= f.config.FailureThreshold {
+		b.openUntil = f.now().Add(f.config.Cooldown)
+		f.config.Logger.Error(`mandrill: provider circuit opened`, `provider`, b.Name, `failures`, b.failures, `until`, b.openUntil)
+	}
+}
+
+// bulkMailContext calls BulkMailContext if the Mailer supports it
+func bulkMailContext(ctx context.Context, m Mailer, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	if mc, ok := m.(MailerContext); ok {
+		return mc.BulkMailContext(ctx, recipients, message, params)
+	}
+
+	return m.BulkMail(recipients, message, params)
+}
+
+// splitUnsent separates the responses for recipients a failed send decided on, by sending, rejecting or
+// suppressing them, from the recipients it didn't send. Every Mailer returns a partialError when it decided on
+// some recipients, so any other error means none of them were.
+func splitUnsent(resp []MailRecipientResponse, recipients []MailRecipient, err error) ([]MailRecipientResponse, []MailRecipient) {
+
+	var partialErr partialError
+	if !errors.As(err, &partialErr) {
+		return nil, recipients
+	}
+
+	unsent := partialErr.unsent()
+	pending := make(map[string]int, len(unsent))
+	for _, r := range unsent {
+		pending[strings.ToLower(r.Email)]++
+	}
+
+	var sent []MailRecipientResponse
+	for _, r := range resp {
+		email := strings.ToLower(r.Email)
+		if r.Status == MAIL_MESSAGE_UNKNOWN && pending[email] > 0 {
+			pending[email]--
+			continue
+		}
+		sent = append(sent, r)
+	}
+
+	return sent, unsent
+}
+
+// withProvider sets Provider on each response, unless a nested FailoverMailer or SplitMailer already has
+func withProvider(resp []MailRecipientResponse, provider string) []MailRecipientResponse {
+
+	for i := range resp {
+		if resp[i].Provider == `` {
+			resp[i].Provider = provider
+		}
+	}
+
+	return resp
+}
+
+// withProviderSingle sets Provider on a single response, unless a nested FailoverMailer or SplitMailer already
+// has
+func withProviderSingle(r *MailRecipientResponse, provider string) *MailRecipientResponse {
+
+	if r != nil && r.Provider == `` {
+		r.Provider = provider
+	}
+
+	return r
+}
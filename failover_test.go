@@ -0,0 +1,309 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jjharr/mandrill-mail/mandrilltest"
+)
+
+// countingMailer counts BulkMail calls, and hides the Context methods of the Mailer it wraps
+type countingMailer struct {
+	Mailer
+	calls int
+}
+
+func (c *countingMailer) BulkMail(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	c.calls++
+	return c.Mailer.BulkMail(recipients, message, params)
+}
+
+func TestFailoverMailer_PartialFailure(t *testing.T) {
+
+	// the primary sends each recipient in its own chunk: down@ fails, rejected@ is rejected
+	primary := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p struct {
+			Message struct {
+				To []struct {
+					Email string `json:"email"`
+				} `json:"to"`
+			} `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&p)
+
+		switch email := p.Message.To[0].Email; email {
+		case `down@example.com`:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"error","code":-99,"name":"ServiceUnavailable","message":"Try again"}`))
+		case `rejected@example.com`:
+			w.Write([]byte(`[{"email":"` + email + `","status":"rejected","reject_reason":"hard-bounce","_id":"r1"}]`))
+		default:
+			w.Write([]byte(`[{"email":"` + email + `","status":"sent","_id":"s1"}]`))
+		}
+	}, WithBatching(1, 1))
+
+	secondary := NewFakeMailer()
+
+	f, err := NewFailoverMailer([]FailoverBackend{
+		{Name: `mandrill`, Mailer: primary},
+		{Name: `smtp`, Mailer: secondary},
+	}, FailoverConfig{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := []MailRecipient{
+		{Email: `ok@example.com`, RecipientType: MAIL_TO},
+		{Email: `down@example.com`, RecipientType: MAIL_TO},
+		{Email: `rejected@example.com`, RecipientType: MAIL_TO},
+	}
+
+	resp, err := f.BulkMail(recipients, newTestMessage(), nil)
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	expected := []struct {
+		email    string
+		status   MailStatus
+		provider string
+	}{
+		{`ok@example.com`, MAIL_MESSAGE_SENT, `mandrill`},
+		{`down@example.com`, MAIL_MESSAGE_SENT, `smtp`},
+		{`rejected@example.com`, MAIL_MESSAGE_REJECTED, `mandrill`},
+	}
+	if len(resp) != len(expected) {
+		t.Fatalf("Expected %d responses, got %+v", len(expected), resp)
+	}
+	for i, e := range expected {
+		if resp[i].Email != e.email || resp[i].Status != e.status || resp[i].Provider != e.provider {
+			t.Errorf("Unexpected response %d : %+v", i, resp[i])
+		}
+	}
+
+	// only the recipient in the failed chunk is sent again, never the rejected one
+	sent := secondary.Messages()
+	if len(sent) != 1 || len(sent[0].Recipients) != 1 || sent[0].Recipients[0].Email != `down@example.com` {
+		t.Errorf("Unexpected messages sent through the secondary : %+v", sent)
+	}
+}
+
+func TestFailoverMailer_DecidedRecipients(t *testing.T) {
+
+	// unavailable answers every send with a 503, except for the reject list
+	unavailable := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == MANDRILL_REJECTS_LIST_PATH {
+			w.Write([]byte(`[{"email":"decided@example.com","reason":"hard-bounce"}]`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"error","code":-99,"name":"ServiceUnavailable","message":"Try again"}`))
+	}
+
+	// bounceThenFail rejects decided@ and fails for anyone after
+	bounceThenFail := func(w http.ResponseWriter, r *http.Request) {
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+		if p.Message.To[0].Email == `decided@example.com` {
+			w.Write([]byte(`[{"email":"decided@example.com","status":"rejected","reject_reason":"hard-bounce"}]`))
+			return
+		}
+		unavailable(w, r)
+	}
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+	server.DeferRecipient(`pending@example.com`)
+
+	tests := []struct {
+		name      string
+		primary   func() Mailer
+		mergeMode MergeMode
+		status    MailStatus
+	}{
+		{`suppressed`, func() Mailer {
+			return initLocalData(t, unavailable,
+				WithSuppressionStore(NewMemorySuppressionStore(Suppression{Email: `decided@example.com`}), SUPPRESSION_DROP))
+		}, MERGE_MODE_NONE, MAIL_MESSAGE_REJECTED},
+		{`local merge`, func() Mailer {
+			return initLocalData(t, bounceThenFail)
+		}, MERGE_MODE_LOCAL, MAIL_MESSAGE_REJECTED},
+		{`reject list`, func() Mailer {
+			return initLocalData(t, unavailable, WithRejectCheck(time.Hour))
+		}, MERGE_MODE_NONE, MAIL_MESSAGE_REJECTED},
+		{`smtp`, func() Mailer {
+			return initSMTPMailer(t, server)
+		}, MERGE_MODE_LOCAL, MAIL_MESSAGE_SENT},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			secondary := NewFakeMailer()
+			f, err := NewFailoverMailer([]FailoverBackend{
+				{Name: `primary`, Mailer: test.primary()},
+				{Name: `secondary`, Mailer: secondary},
+			}, FailoverConfig{})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			recipients := []MailRecipient{
+				{Email: `decided@example.com`, RecipientType: MAIL_TO},
+				{Email: `pending@example.com`, RecipientType: MAIL_TO},
+			}
+			message := newTestMessage()
+			message.MergeMode = test.mergeMode
+
+			resp, err := f.BulkMail(recipients, message, new(SendParams))
+			if err != nil {
+				t.Fatalf("BulkMail failed with error : %s", err.Error())
+			}
+
+			// the primary's decision on decided@ stands, and only pending@ is sent again
+			if len(resp) != 2 || resp[0].Status != test.status || resp[0].Provider != `primary` ||
+				resp[1].Email != `pending@example.com` || resp[1].Provider != `secondary` {
+				t.Errorf("Unexpected responses %+v", resp)
+			}
+
+			sent := secondary.Messages()
+			if len(sent) != 1 || len(sent[0].Recipients) != 1 || sent[0].Recipients[0].Email != `pending@example.com` {
+				t.Errorf("Unexpected messages sent through the secondary : %+v", sent)
+			}
+		})
+	}
+}
+
+func TestFailoverMailer_CircuitBreaker(t *testing.T) {
+
+	primaryFake := NewFakeMailer()
+	primaryFake.Err = &APIError{HTTPStatus: http.StatusInternalServerError, Name: MANDRILL_ERROR_GENERAL}
+	primary := &countingMailer{Mailer: primaryFake}
+	secondary := &countingMailer{Mailer: NewFakeMailer()}
+
+	f, err := NewFailoverMailer([]FailoverBackend{
+		{Name: `primary`, Mailer: primary},
+		{Name: `secondary`, Mailer: secondary},
+	}, FailoverConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	send := func() []MailRecipientResponse {
+		resp, err := f.BulkMail(recipients, newTestMessage(), nil)
+		if err != nil {
+			t.Fatalf("BulkMail failed with error : %s", err.Error())
+		}
+		return resp
+	}
+
+	send()
+	send()
+	if primary.calls != 2 || secondary.calls != 2 {
+		t.Fatalf("Expected both sends to fail over, got %d and %d calls", primary.calls, secondary.calls)
+	}
+
+	// the circuit is open, so the primary is skipped
+	if resp := send(); primary.calls != 2 || resp[0].Provider != `secondary` {
+		t.Errorf("Expected the primary to be skipped, got %d calls and %+v", primary.calls, resp)
+	}
+
+	// after the cooldown one send tests the primary, and its success closes the circuit
+	now = now.Add(2 * time.Minute)
+	primaryFake.Err = nil
+	if resp := send(); primary.calls != 3 || resp[0].Provider != `primary` {
+		t.Errorf("Expected the primary to be tried again, got %d calls and %+v", primary.calls, resp)
+	}
+	if send(); primary.calls != 4 || secondary.calls != 3 {
+		t.Errorf("Expected the primary to be used while it succeeds, got %d and %d calls", primary.calls, secondary.calls)
+	}
+}
+
+func TestFailoverMailer_AllFail(t *testing.T) {
+
+	primary := NewFakeMailer()
+	primary.Err = &APIError{HTTPStatus: http.StatusInternalServerError, Name: MANDRILL_ERROR_INVALID_KEY}
+	secondary := NewFakeMailer()
+	secondary.Err = errors.New(`connection refused`)
+
+	f, err := NewFailoverMailer([]FailoverBackend{
+		{Name: `primary`, Mailer: primary},
+		{Name: `secondary`, Mailer: secondary},
+	}, FailoverConfig{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+	}
+	resp, err := f.BulkMail(recipients, newTestMessage(), nil)
+
+	var failoverErr *FailoverError
+	if !errors.As(err, &failoverErr) || len(failoverErr.Recipients) != 2 || len(failoverErr.Errors) != 2 {
+		t.Fatalf("Expected a FailoverError for both recipients, got %v", err)
+	}
+	if !IsInvalidKey(err) {
+		t.Errorf("Expected the primary's API error to be exposed")
+	}
+	if len(resp) != 2 || resp[0].Status != MAIL_MESSAGE_UNKNOWN || resp[0].Error != `connection refused` {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	if _, err := f.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`); !errors.As(err, &failoverErr) {
+		t.Errorf("Expected a FailoverError from SimpleMail, got %v", err)
+	}
+
+	secondary.Err = nil
+	r, err := f.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`)
+	if err != nil || r.Provider != `secondary` || r.Status != MAIL_MESSAGE_SENT {
+		t.Errorf("Expected SimpleMail to fail over, got %+v and %v", r, err)
+	}
+}
+
+func TestFailoverMailer_NoFailover(t *testing.T) {
+
+	primary := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Suppressed recipients should not be sent")
+	}, WithSuppressionStore(NewMemorySuppressionStore(Suppression{Email: `to@example.com`}), SUPPRESSION_FAIL))
+	secondary := NewFakeMailer()
+
+	f, err := NewFailoverMailer([]FailoverBackend{
+		{Name: `mandrill`, Mailer: primary},
+		{Name: `smtp`, Mailer: secondary},
+	}, FailoverConfig{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+
+	var suppressionErr *SuppressionError
+	if _, err := f.BulkMail(recipients, newTestMessage(), nil); !errors.As(err, &suppressionErr) {
+		t.Errorf("Expected the suppression error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	other := []MailRecipient{{Email: `other@example.com`, RecipientType: MAIL_TO}}
+	if _, err := f.BulkMailContext(ctx, other, newTestMessage(), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+
+	if _, err := f.BulkMail([]MailRecipient{{Email: `to@example.com`}}, newTestMessage(), nil); err == nil {
+		t.Errorf("Expected a validation error")
+	}
+
+	if len(secondary.Messages()) != 0 {
+		t.Errorf("Expected nothing to be sent through the secondary")
+	}
+}
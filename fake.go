@@ -0,0 +1,294 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+)
+
+// FakeMailer is an in-memory Mailer for application unit tests. It renders messages the same way the real
+// client does, records everything it is asked to send, and answers with configurable per-recipient outcomes.
+// Nothing leaves the process. A FakeMailer is safe for concurrent use.
+type FakeMailer struct {
+	// Sender is used as the From address when a message doesn't set one (TemplateMail never does)
+	Sender *MailRecipient
+	// Err, if set, is returned from every call and nothing is recorded
+	Err error
+
+	mu       sync.Mutex
+	outcomes map[string]MailRecipientResponse
+	sent     []SentMessage
+	nextId   int
+}
+
+// SentMessage is a message recorded by a FakeMailer, with its content already rendered
+type SentMessage struct {
+	Recipients []MailRecipient
+	Message    *MailMessage
+	Params     *SendParams
+	From       string
+	Subject    string
+	Html       string
+	Text       string
+	Responses  []MailRecipientResponse
+}
+
+var _ MailerContext = new(FakeMailer)
+
+// NewFakeMailer creates a FakeMailer that sends everything successfully until told otherwise
+func NewFakeMailer() *FakeMailer {
+
+	return &FakeMailer{
+		Sender: &MailRecipient{
+			Name:  `Fake Sender`,
+			Email: `sender@example.com`,
+		},
+		outcomes: map[string]MailRecipientResponse{},
+	}
+}
+
+// SetOutcome makes every future send to email report the supplied status and reject reason
+func (f *FakeMailer) SetOutcome(email string, status MailStatus, reason RejectReason) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.outcomes[strings.ToLower(email)] = MailRecipientResponse{
+		Status:       status,
+		RejectReason: reason,
+		Error:        string(reason),
+	}
+}
+
+// Reject makes every future send to email report MAIL_MESSAGE_REJECTED for the supplied reason
+func (f *FakeMailer) Reject(email string, reason RejectReason) {
+	f.SetOutcome(email, MAIL_MESSAGE_REJECTED, reason)
+}
+
+// Queue makes every future send to email report MAIL_MESSAGE_QUEUED
+func (f *FakeMailer) Queue(email string) {
+	f.SetOutcome(email, MAIL_MESSAGE_QUEUED, ``)
+}
+
+// Messages returns every recorded message, oldest first
+func (f *FakeMailer) Messages() []SentMessage {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]SentMessage(nil), f.sent...)
+}
+
+// LastMessage returns the most recently recorded message, or nil if nothing has been sent
+func (f *FakeMailer) LastMessage() *SentMessage {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.sent) == 0 {
+		return nil
+	}
+
+	msg := f.sent[len(f.sent)-1]
+	return &msg
+}
+
+// SentTo returns the recorded messages that were accepted (sent, queued or scheduled) for email
+func (f *FakeMailer) SentTo(email string) []SentMessage {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matches []SentMessage
+	for _, msg := range f.sent {
+		for _, r := range msg.Responses {
+			if strings.EqualFold(r.Email, email) && isAcceptedStatus(r.Status) {
+				matches = append(matches, msg)
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// Reset forgets all recorded messages and configured outcomes
+func (f *FakeMailer) Reset() {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = nil
+	f.outcomes = map[string]MailRecipientResponse{}
+}
+
+// SimpleMail records a plain text message to a single recipient
+func (f *FakeMailer) SimpleMail(from, to, subject, body string) (*MailRecipientResponse, error) {
+	return f.SimpleMailContext(context.Background(), from, to, subject, body)
+}
+
+// SimpleMailContext is SimpleMail with a context. The fake fails if ctx is already done.
+func (f *FakeMailer) SimpleMailContext(ctx context.Context, from, to, subject, body string) (*MailRecipientResponse, error) {
+
+	from = strings.TrimSpace(from)
+	to = strings.TrimSpace(to)
+	subject = strings.TrimSpace(subject)
+
+	if from == `` {
+		return nil, errors.New("SimpleMail: Must specify source email address;")
+	}
+	if to == `` {
+		return nil, errors.New("SimpleMail: Must specify destination email address;")
+	}
+	if subject == `` {
+		return nil, errors.New("SimpleMail: Must specify subject;")
+	}
+
+	msg := SentMessage{
+		Recipients: []MailRecipient{{Email: to, RecipientType: MAIL_TO}},
+		From:       from,
+		Subject:    subject,
+		Text:       body,
+	}
+
+	resp, err := f.record(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp[0], nil
+}
+
+// TemplateMail renders the template with vars and records it for a single recipient
+func (f *FakeMailer) TemplateMail(toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+	return f.TemplateMailContext(context.Background(), toEmail, subject, template, vars)
+}
+
+// TemplateMailContext is TemplateMail with a context. The fake fails if ctx is already done.
+func (f *FakeMailer) TemplateMailContext(ctx context.Context, toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+
+	if toEmail == `` {
+		return nil, errors.New("TemplateMail: Must specify destination email address;")
+	} else if template == nil {
+		return nil, errors.New("TemplateMail: Must specify template;")
+	}
+
+	message := &MailMessage{
+		HTMLTemplate: template,
+		TemplateVars: vars,
+		Subject:      subject,
+		From:         f.Sender,
+	}
+	recipients := []MailRecipient{{Email: toEmail, RecipientType: MAIL_TO}}
+
+	resp, err := f.BulkMailContext(ctx, recipients, message, new(SendParams))
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp[0], nil
+}
+
+// BulkMail renders the message and records it for all recipients
+func (f *FakeMailer) BulkMail(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	return f.BulkMailContext(context.Background(), recipients, message, params)
+}
+
+// BulkMailContext is BulkMail with a context. The fake fails if ctx is already done.
+func (f *FakeMailer) BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, err
+	}
+
+	// like the real client, local merging renders and sends a separate message per recipient
+	if message.MergeMode == MERGE_MODE_LOCAL {
+		resp := make([]MailRecipientResponse, 0, len(recipients))
+		for i := range recipients {
+			r, err := f.render(ctx, recipients[i:i+1], message, params, recipientTemplateVars(message, &recipients[i]))
+			if err != nil {
+				return unsentAfter(resp, recipients[i:], err)
+			}
+			resp = append(resp, r...)
+		}
+		return resp, nil
+	}
+
+	return f.render(ctx, recipients, message, params, message.TemplateVars)
+}
+
+// render renders message with vars and records it for recipients
+func (f *FakeMailer) render(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams, vars map[string]string) ([]MailRecipientResponse, error) {
+
+	html, text, err := renderMessageContent(message, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	from := f.Sender.Email
+	if message.From != nil && message.From.Email != `` {
+		from = message.From.Email
+	}
+
+	return f.record(ctx, SentMessage{
+		Recipients: recipients,
+		Message:    message,
+		Params:     params,
+		From:       from,
+		Subject:    message.Subject,
+		Html:       html,
+		Text:       text,
+	})
+}
+
+// record stores msg after filling in a response for each of its recipients
+func (f *FakeMailer) record(ctx context.Context, msg SentMessage) ([]MailRecipientResponse, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	status := MAIL_MESSAGE_SENT
+	if msg.Params != nil && msg.Params.SendAsync {
+		status = MAIL_MESSAGE_QUEUED
+	}
+
+	msg.Responses = make([]MailRecipientResponse, len(msg.Recipients), len(msg.Recipients))
+	for i, r := range msg.Recipients {
+
+		f.nextId++
+		resp, ok := f.outcomes[strings.ToLower(r.Email)]
+		if !ok {
+			resp = MailRecipientResponse{Status: status}
+		}
+		resp.Id = fmt.Sprintf("fake-%d", f.nextId)
+		resp.Email = r.Email
+
+		msg.Responses[i] = resp
+	}
+
+	f.sent = append(f.sent, msg)
+
+	return append([]MailRecipientResponse(nil), msg.Responses...), nil
+}
+
+// isAcceptedStatus reports whether a provider accepted a recipient for delivery
+func isAcceptedStatus(status MailStatus) bool {
+	return status == MAIL_MESSAGE_SENT || status == MAIL_MESSAGE_QUEUED || status == MAIL_MESSAGE_SCHEDULED
+}
@@ -0,0 +1,97 @@
+package mandrillmail
+
+import (
+	"errors"
+	"html/template"
+	"testing"
+)
+
+func TestFakeMailer_BulkMail(t *testing.T) {
+
+	f := NewFakeMailer()
+	f.Reject(`bounced@example.com`, MAIL_REJECT_HARD_BOUNCE)
+
+	tmpl := template.Must(template.New(`fake_test`).Parse(`Hello {{.Name}}`))
+	message := &MailMessage{
+		HTMLTemplate: tmpl,
+		TemplateVars: map[string]string{`Name`: `World`},
+		Subject:      `Fake Test`,
+		From:         &MailRecipient{Email: `from@example.com`},
+	}
+	recipients := []MailRecipient{
+		{Email: `ok@example.com`, RecipientType: MAIL_TO},
+		{Email: `bounced@example.com`, RecipientType: MAIL_CC},
+	}
+
+	resp, err := f.BulkMail(recipients, message, &SendParams{})
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if resp[0].Status != MAIL_MESSAGE_SENT || resp[1].Status != MAIL_MESSAGE_REJECTED || resp[1].RejectReason != MAIL_REJECT_HARD_BOUNCE {
+		t.Errorf("Unexpected responses : %+v", resp)
+	}
+
+	last := f.LastMessage()
+	if last == nil || last.Html != `Hello World` || last.From != `from@example.com` {
+		t.Fatalf("Unexpected last message : %+v", last)
+	}
+
+	if len(f.SentTo(`OK@example.com`)) != 1 || len(f.SentTo(`bounced@example.com`)) != 0 {
+		t.Error("SentTo should only match accepted recipients")
+	}
+}
+
+func TestFakeMailer_Err(t *testing.T) {
+
+	f := NewFakeMailer()
+	f.Err = errors.New(`boom`)
+
+	if _, err := f.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`); err != f.Err {
+		t.Errorf("Expected configured error, got %v", err)
+	}
+
+	if f.LastMessage() != nil {
+		t.Error("Failed sends should not be recorded")
+	}
+}
+
+func TestFakeMailer_LocalPartialFailure(t *testing.T) {
+
+	f := NewFakeMailer()
+
+	// rendering fails for the second recipient only
+	tmpl := template.Must(template.New(`fake_local_test`).Funcs(template.FuncMap{
+		`check`: func(name string) (string, error) {
+			if name == `Bob` {
+				return ``, errors.New(`bad name`)
+			}
+			return name, nil
+		},
+	}).Parse(`Hello {{check .Name}}`))
+	message := &MailMessage{
+		HTMLTemplate: tmpl,
+		Subject:      `Fake Test`,
+		From:         &MailRecipient{Email: `from@example.com`},
+		MergeMode:    MERGE_MODE_LOCAL,
+	}
+	recipients := []MailRecipient{
+		{Email: `ann@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`Name`: `Ann`}},
+		{Email: `bob@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`Name`: `Bob`}},
+	}
+
+	resp, err := f.BulkMail(recipients, message, &SendParams{})
+
+	var unsentErr *UnsentError
+	if !errors.As(err, &unsentErr) || len(unsentErr.Recipients) != 1 || unsentErr.Recipients[0].Email != `bob@example.com` {
+		t.Fatalf("Expected an UnsentError for the second recipient, got %v", err)
+	}
+
+	if len(resp) != 2 || resp[0].Status != MAIL_MESSAGE_SENT || resp[1].Status != MAIL_MESSAGE_UNKNOWN {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	if len(f.Messages()) != 1 {
+		t.Errorf("Expected only the first message to be recorded")
+	}
+}
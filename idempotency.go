@@ -0,0 +1,164 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// IDEMPOTENCY_METADATA_KEY is the message metadata key under which SendParams.IdempotencyKey is sent to
+// Mandrill, so that duplicates can be traced in its activity log and webhooks
+const IDEMPOTENCY_METADATA_KEY = `idempotency_key`
+
+// IdempotencyStore remembers the responses of completed sends by idempotency key. Implementations must be
+// safe for concurrent use. A shared store (e.g. backed by a database) extends deduplication across processes.
+type IdempotencyStore interface {
+	// Get returns the responses recorded for key, if they haven't expired
+	Get(key string) ([]MailRecipientResponse, bool, error)
+	// Put records the responses for key, to be forgotten after ttl
+	Put(key string, responses []MailRecipientResponse, ttl time.Duration) error
+}
+
+// WithIdempotencyStore deduplicates sends that carry a SendParams.IdempotencyKey, remembering each
+// successful send for ttl. Concurrent sends with the same key in one process wait for the first to finish.
+func WithIdempotencyStore(store IdempotencyStore, ttl time.Duration) MandrillOption {
+	return func(m *mandrill) error {
+		if ttl <= 0 {
+			return errors.New("WithIdempotencyStore: ttl must be positive")
+		}
+		m.dedup = store
+		m.dedupTTL = ttl
+		return nil
+	}
+}
+
+// idempotent runs send unless a send with the same idempotency key has already succeeded, in which case its
+// responses are returned instead. Only fully successful sends are recorded.
+func (m *mandrill) idempotent(ctx context.Context, params *SendParams, send func() ([]MailRecipientResponse, error)) ([]MailRecipientResponse, error) {
+
+	if m.dedup == nil || params == nil || params.IdempotencyKey == `` {
+		return send()
+	}
+
+	key := params.IdempotencyKey
+	release, err := m.acquireKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, ok, err := m.dedup.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		m.logger.Debug(`mandrill: skipped duplicate send`, `idempotency_key`, key)
+		return resp, nil
+	}
+
+	resp, err = send()
+	if err != nil {
+		return resp, err
+	}
+
+	if err := m.dedup.Put(key, resp, m.dedupTTL); err != nil {
+		m.logger.Error(`mandrill: failed to record idempotency key`, `idempotency_key`, key, `error`, err)
+	}
+
+	return resp, nil
+}
+
+// acquireKey waits until no other send in this process holds key, then holds it until release is called
+func (m *mandrill) acquireKey(ctx context.Context, key string) (func(), error) {
+
+	for {
+		m.inflightMu.Lock()
+		wait, busy := m.inflight[key]
+		if !busy {
+			done := make(chan struct{})
+			m.inflight[key] = done
+			m.inflightMu.Unlock()
+
+			return func() {
+				m.inflightMu.Lock()
+				delete(m.inflight, key)
+				m.inflightMu.Unlock()
+				close(done)
+			}, nil
+		}
+		m.inflightMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore. Expired keys are dropped lazily.
+type MemoryIdempotencyStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	responses []MailRecipientResponse
+	expires   time.Time
+}
+
+var _ IdempotencyStore = new(MemoryIdempotencyStore)
+
+// NewMemoryIdempotencyStore creates an empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+
+	return &MemoryIdempotencyStore{
+		now:     time.Now,
+		entries: map[string]idempotencyEntry{},
+	}
+}
+
+func (s *MemoryIdempotencyStore) Get(key string) ([]MailRecipientResponse, bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if !s.now().Before(e.expires) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+
+	return append([]MailRecipientResponse(nil), e.responses...), true, nil
+}
+
+func (s *MemoryIdempotencyStore) Put(key string, responses []MailRecipientResponse, ttl time.Duration) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	// sweep expired keys now and then, so the map doesn't grow without bound
+	if len(s.entries) > 0 && len(s.entries)%1024 == 0 {
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	s.entries[key] = idempotencyEntry{
+		responses: append([]MailRecipientResponse(nil), responses...),
+		expires:   now.Add(ttl),
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMandrill_IdempotencyKey(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		requests int
+		metadata map[string]string
+	)
+	store := NewMemoryIdempotencyStore()
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+
+		mu.Lock()
+		requests++
+		metadata = p.Message.Metadata
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent","_id":"abc123"}]`))
+	}, WithIdempotencyStore(store, time.Hour))
+
+	message := newTestMessage()
+	message.Metadata = map[string]string{`user`: `42`}
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	params := &SendParams{IdempotencyKey: `signup-42`}
+
+	var wg sync.WaitGroup
+	responses := make([][]MailRecipientResponse, 3)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := m.BulkMail(recipients, message, params)
+			if err != nil {
+				t.Errorf("BulkMail failed with error : %s", err.Error())
+			}
+			responses[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	if requests != 1 {
+		t.Fatalf("Expected a single request for the idempotency key, got %d", requests)
+	}
+
+	for _, resp := range responses {
+		if len(resp) != 1 || resp[0].Id != `abc123` {
+			t.Errorf("Duplicate sends should return the original responses, got %+v", resp)
+		}
+	}
+
+	if metadata[IDEMPOTENCY_METADATA_KEY] != `signup-42` || metadata[`user`] != `42` {
+		t.Errorf("Unexpected metadata sent : %+v", metadata)
+	}
+
+	if _, ok := message.Metadata[IDEMPOTENCY_METADATA_KEY]; ok {
+		t.Error("The caller's metadata should not be modified")
+	}
+
+	// once the key expires the message can be sent again
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := m.BulkMail(recipients, message, params); err != nil || requests != 2 {
+		t.Errorf("Expected a new send after the key expired, got %d requests and %v", requests, err)
+	}
+}
@@ -0,0 +1,268 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+)
+
+// @see https://mailchimp.com/developer/transactional/guides/set-up-inbound-email-processing/
+
+const WEBHOOK_EVENT_INBOUND WebhookEventType = `inbound`
+
+// InboundMessage is an email received by a Mandrill inbound route
+type InboundMessage struct {
+	// Email is the inbound address the message was routed by, which may not appear in To or Cc
+	Email     string
+	FromEmail string
+	FromName  string
+	To        []MailRecipient
+	Cc        []MailRecipient
+	Subject   string
+	Text      string
+	Html      string
+	Headers   textproto.MIMEHeader
+	// Attachments and Images are sorted by name. Their content is always base64 encoded.
+	Attachments []EmailAttachment
+	Images      []EmailAttachment
+	Tags        []string
+	SpamReport  InboundSpamReport
+	DKIM        InboundDKIM
+	SPF         InboundSPF
+	// RawMessage is the full message as received
+	RawMessage string
+}
+
+type InboundSpamReport struct {
+	Score        float64           `json:"score"`
+	MatchedRules []InboundSpamRule `json:"matched_rules"`
+}
+
+type InboundSpamRule struct {
+	Name        string  `json:"name"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description"`
+}
+
+type InboundDKIM struct {
+	Signed bool `json:"signed"`
+	Valid  bool `json:"valid"`
+}
+
+type InboundSPF struct {
+	// Result is one of pass, neutral, fail, softfail, temperror, permerror or none
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+// mandrillInboundEvent is the wire format of an inbound event
+type mandrillInboundEvent struct {
+	Msg struct {
+		RawMsg      string                               `json:"raw_msg"`
+		Headers     map[string]json.RawMessage           `json:"headers"`
+		Text        string                               `json:"text"`
+		Html        string                               `json:"html"`
+		FromEmail   string                               `json:"from_email"`
+		FromName    string                               `json:"from_name"`
+		To          [][]*string                          `json:"to"`
+		Cc          [][]*string                          `json:"cc"`
+		Email       string                               `json:"email"`
+		Subject     string                               `json:"subject"`
+		Tags        []string                             `json:"tags"`
+		SpamReport  InboundSpamReport                    `json:"spam_report"`
+		DKIM        InboundDKIM                          `json:"dkim"`
+		SPF         InboundSPF                           `json:"spf"`
+		Attachments map[string]mandrillInboundAttachment `json:"attachments"`
+		Images      map[string]mandrillInboundAttachment `json:"images"`
+	} `json:"msg"`
+}
+
+type mandrillInboundAttachment struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Base64  bool   `json:"base64"`
+}
+
+// ParseInboundMessage decodes the message carried by an inbound webhook event
+func ParseInboundMessage(event *WebhookEvent) (*InboundMessage, error) {
+
+	if event.Event != WEBHOOK_EVENT_INBOUND {
+		return nil, errors.New("ParseInboundMessage: not an inbound event;")
+	}
+
+	var raw mandrillInboundEvent
+	if err := json.Unmarshal(event.Raw, &raw); err != nil {
+		return nil, err
+	}
+
+	headers, err := parseMandrillHeaders(raw.Msg.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InboundMessage{
+		Email:       raw.Msg.Email,
+		FromEmail:   raw.Msg.FromEmail,
+		FromName:    raw.Msg.FromName,
+		To:          parseInboundRecipients(raw.Msg.To, MAIL_TO),
+		Cc:          parseInboundRecipients(raw.Msg.Cc, MAIL_CC),
+		Subject:     raw.Msg.Subject,
+		Text:        raw.Msg.Text,
+		Html:        raw.Msg.Html,
+		Headers:     headers,
+		Attachments: parseInboundAttachments(raw.Msg.Attachments),
+		Images:      parseInboundAttachments(raw.Msg.Images),
+		Tags:        raw.Msg.Tags,
+		SpamReport:  raw.Msg.SpamReport,
+		DKIM:        raw.Msg.DKIM,
+		SPF:         raw.Msg.SPF,
+		RawMessage:  raw.Msg.RawMsg,
+	}, nil
+}
+
+// parseMandrillHeaders converts headers as Mandrill returns them, where repeated headers are arrays and
+// others are strings, to a MIMEHeader
+func parseMandrillHeaders(raw map[string]json.RawMessage) (textproto.MIMEHeader, error) {
+
+	headers := textproto.MIMEHeader{}
+	for name, value := range raw {
+
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			var single string
+			if err := json.Unmarshal(value, &single); err != nil {
+				return nil, errors.New("invalid value for header " + name)
+			}
+			values = []string{single}
+		}
+
+		for _, v := range values {
+			headers.Add(name, v)
+		}
+	}
+
+	return headers, nil
+}
+
+// parseInboundRecipients converts Mandrill's [email, name] pairs, where the name may be null
+func parseInboundRecipients(pairs [][]*string, recipientType MailRecipientType) []MailRecipient {
+
+	var recipients []MailRecipient
+	for _, pair := range pairs {
+		if len(pair) == 0 || pair[0] == nil {
+			continue
+		}
+
+		r := MailRecipient{
+			Email:         *pair[0],
+			RecipientType: recipientType,
+		}
+		if len(pair) > 1 && pair[1] != nil {
+			r.Name = *pair[1]
+		}
+		recipients = append(recipients, r)
+	}
+
+	return recipients
+}
+
+// parseInboundAttachments converts Mandrill's attachments, keyed by name, to EmailAttachments sorted by
+// name. Text attachments are sent unencoded, so they're encoded here for consistency.
+func parseInboundAttachments(raw map[string]mandrillInboundAttachment) []EmailAttachment {
+
+	if len(raw) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attachments := make([]EmailAttachment, len(names), len(names))
+	for i, name := range names {
+		a := raw[name]
+
+		content := a.Content
+		if !a.Base64 {
+			content = base64.StdEncoding.EncodeToString([]byte(a.Content))
+		}
+
+		if a.Name != `` {
+			name = a.Name
+		}
+
+		attachments[i] = EmailAttachment{
+			Name:          name,
+			MimeType:      a.Type,
+			Base64Content: content,
+		}
+	}
+
+	return attachments
+}
+
+// InboundCallback handles an inbound message. As with WebhookCallback, returning an error makes Mandrill
+// retry the batch.
+type InboundCallback func(ctx context.Context, msg *InboundMessage) error
+
+type inboundRoute struct {
+	pattern  string
+	callback InboundCallback
+}
+
+// OnInbound routes inbound messages whose inbound address matches pattern to callback. Patterns use
+// path.Match syntax and are matched case insensitively, e.g. `support@example.com` or `reply+*@example.com`.
+// Each message goes to the first route that matches, in the order they were registered; messages matching
+// no route are acknowledged and dropped.
+func (h *WebhookHandler) OnInbound(pattern string, callback InboundCallback) error {
+
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ``); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.routes = append(h.routes, inboundRoute{
+		pattern:  pattern,
+		callback: callback,
+	})
+
+	return nil
+}
+
+// route parses an inbound event and passes it to the first matching route
+func (h *WebhookHandler) route(ctx context.Context, event *WebhookEvent) error {
+
+	h.mu.RLock()
+	routes := h.routes
+	h.mu.RUnlock()
+
+	if len(routes) == 0 {
+		return nil
+	}
+
+	msg, err := ParseInboundMessage(event)
+	if err != nil {
+		return err
+	}
+
+	email := strings.ToLower(msg.Email)
+	for _, r := range routes {
+		// patterns are validated when added
+		if ok, _ := path.Match(r.pattern, email); ok {
+			return r.callback(ctx, msg)
+		}
+	}
+
+	return nil
+}
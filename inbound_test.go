@@ -0,0 +1,96 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+const testInboundEvents = `[{"event":"inbound","ts":1700000000,"msg":{
+	"raw_msg":"Received: from mail.example.org\n...",
+	"headers":{"Received":["from a","from b"],"Subject":"Re: Your booking","Dkim-Signature":"v=1; a=rsa-sha256"},
+	"text":"Thanks!","html":"<p>Thanks!</p>",
+	"from_email":"customer@example.org","from_name":"Customer",
+	"to":[["reply+42@replies.example.com",null],["support@example.com","Support"]],
+	"email":"reply+42@replies.example.com","subject":"Re: Your booking","tags":[],"sender":null,
+	"spam_report":{"score":-0.8,"matched_rules":[{"name":"DKIM_VALID","score":-0.1,"description":"Message has a valid DKIM signature"}]},
+	"dkim":{"signed":true,"valid":true},
+	"spf":{"result":"pass","detail":"sender SPF authorized"},
+	"attachments":{"notes.txt":{"name":"notes.txt","type":"text/plain","content":"see attached","base64":false}},
+	"images":{"logo.png":{"name":"logo.png","type":"image/png","content":"iVBORw0KGgo=","base64":true}}
+}}]`
+
+func TestWebhookHandler_Inbound(t *testing.T) {
+
+	h, _ := NewWebhookHandler(testWebhookKey, testWebhookURL)
+
+	var (
+		replies []*InboundMessage
+		support int
+		events  int
+	)
+	if err := h.OnInbound(`Reply+*@replies.example.com`, func(ctx context.Context, msg *InboundMessage) error {
+		replies = append(replies, msg)
+		return nil
+	}); err != nil {
+		t.Fatalf("OnInbound failed with error : %s", err.Error())
+	}
+	h.OnInbound(`*@replies.example.com`, func(ctx context.Context, msg *InboundMessage) error {
+		support++
+		return nil
+	})
+	h.On(WEBHOOK_EVENT_INBOUND, func(ctx context.Context, event *WebhookEvent) error {
+		events++
+		return nil
+	})
+
+	if w := postWebhook(h, testInboundEvents, ``); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	if len(replies) != 1 || support != 0 || events != 1 {
+		t.Fatalf("Expected only the first matching route, got %d replies, %d support, %d events", len(replies), support, events)
+	}
+
+	msg := replies[0]
+	if msg.Email != `reply+42@replies.example.com` || msg.FromEmail != `customer@example.org` || msg.Subject != `Re: Your booking` {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	if msg.Text != `Thanks!` || msg.Html != `<p>Thanks!</p>` {
+		t.Errorf("Unexpected content %q / %q", msg.Text, msg.Html)
+	}
+	if len(msg.To) != 2 || msg.To[0].Name != `` || msg.To[1].Name != `Support` || msg.To[1].RecipientType != MAIL_TO {
+		t.Errorf("Unexpected recipients %+v", msg.To)
+	}
+	if len(msg.Headers[`Received`]) != 2 || msg.Headers.Get(`dkim-signature`) != `v=1; a=rsa-sha256` {
+		t.Errorf("Unexpected headers %v", msg.Headers)
+	}
+	if !msg.DKIM.Valid || msg.SPF.Result != `pass` || msg.SpamReport.Score != -0.8 || len(msg.SpamReport.MatchedRules) != 1 {
+		t.Errorf("Unexpected authentication results %+v %+v %+v", msg.DKIM, msg.SPF, msg.SpamReport)
+	}
+
+	if len(msg.Attachments) != 1 || msg.Attachments[0].MimeType != `text/plain` {
+		t.Fatalf("Unexpected attachments %+v", msg.Attachments)
+	}
+	if content, _ := base64.StdEncoding.DecodeString(msg.Attachments[0].Base64Content); string(content) != `see attached` {
+		t.Errorf("Expected text attachment to be base64 encoded, got %q", msg.Attachments[0].Base64Content)
+	}
+	if len(msg.Images) != 1 || msg.Images[0].Base64Content != `iVBORw0KGgo=` {
+		t.Errorf("Unexpected images %+v", msg.Images)
+	}
+}
+
+func TestWebhookHandler_InboundPattern(t *testing.T) {
+
+	h, _ := NewWebhookHandler(testWebhookKey, testWebhookURL)
+
+	if err := h.OnInbound(`[`, func(ctx context.Context, msg *InboundMessage) error { return nil }); err == nil {
+		t.Errorf("Expected an error for a bad pattern")
+	}
+
+	// unrouted messages are acknowledged so that Mandrill doesn't retry them
+	if w := postWebhook(h, testInboundEvents, ``); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for an unrouted message, got %d", w.Code)
+	}
+}
@@ -0,0 +1,165 @@
+package mandrillmail
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// jsonLog is an append-only JSON lines file that is replayed on open. It backs FileQueueStore and
+// FileSuppressionStore, which hold their own locks around it.
+type jsonLog struct {
+	path string
+	// name describes the store in errors, e.g. "queue store"
+	name string
+	file *os.File
+}
+
+// openJSONLog opens, or creates, the log at path and passes each of its lines, in order, to replay. A torn
+// final line, as left by a crash mid-write, is discarded.
+func openJSONLog(path, name string, replay func(line []byte) error) (*jsonLog, error) {
+
+	l := &jsonLog{
+		path: path,
+		name: name,
+	}
+
+	size, err := l.load(replay)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// drop any torn line so the next entry starts on a line of its own
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file = file
+
+	return l, nil
+}
+
+// load replays the log, and returns its size up to the end of its last complete line
+func (l *jsonLog) load(replay func(line []byte) error) (int64, error) {
+
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var (
+		reader = bufio.NewReader(file)
+		size   int64
+		line   int
+	)
+	for {
+		b, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// anything left over is a torn write
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+		line++
+
+		if err := replay(b); err != nil {
+			return 0, fmt.Errorf("%s: corrupt entry on line %d: %s", l.path, line, err.Error())
+		}
+
+		size += int64(len(b))
+	}
+}
+
+// append writes an entry to the log and syncs it to disk
+func (l *jsonLog) append(entry interface{}) error {
+
+	if l.file == nil {
+		return errors.New(l.name + " is closed")
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+// rewrite replaces the log with entries. The new log is written to a temporary file and renamed over the
+// old one, so a crash leaves one or the other intact.
+func (l *jsonLog) rewrite(entries []interface{}) error {
+
+	if l.file == nil {
+		return errors.New(l.name + " is closed")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+`.compact-*`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	l.file.Close()
+	l.file = file
+
+	return nil
+}
+
+// close closes the log file. The log can't be written afterwards.
+func (l *jsonLog) close() error {
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
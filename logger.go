@@ -0,0 +1,82 @@
+package mandrillmail
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Logger receives structured log output from the client. Args are alternating keys and values, as with
+// log/slog, so a *slog.Logger can be passed to WithLogger directly. Request and response summaries are
+// logged at debug level, with the API key and recipient emails redacted.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger discards everything, and is used when no Logger is configured
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// WithLogger sends the client's log output to logger. By default nothing is logged.
+func WithLogger(logger Logger) MandrillOption {
+	return func(m *mandrill) error {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		m.logger = logger
+		return nil
+	}
+}
+
+// redactKey hides all but the last four characters of an API key
+func redactKey(key string) string {
+
+	if len(key) <= 4 {
+		return strings.Repeat(`*`, len(key))
+	}
+
+	return strings.Repeat(`*`, len(key)-4) + key[len(key)-4:]
+}
+
+// redactEmail keeps the first character of the local part and the domain, e.g. "j***@example.com", which
+// is enough to tell recipients apart in logs without recording who they are.
+func redactEmail(email string) string {
+
+	at := strings.LastIndex(email, `@`)
+	if at < 1 {
+		return `***`
+	}
+
+	return email[:1] + `***` + email[at:]
+}
+
+// redactRecipients lists the redacted emails of the supplied recipients
+func redactRecipients(recipients []mandrillRecipient) []string {
+
+	redacted := make([]string, len(recipients), len(recipients))
+	for i := range recipients {
+		redacted[i] = redactEmail(recipients[i].Email)
+	}
+
+	return redacted
+}
+
+// summarizeStatuses counts responses by status, e.g. "queued=2 sent=10"
+func summarizeStatuses(responses []MailRecipientResponse) string {
+
+	counts := map[MailStatus]int{}
+	for _, v := range responses {
+		counts[v.Status]++
+	}
+
+	parts := make([]string, 0, len(counts))
+	for status, count := range counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", status, count))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ` `)
+}
@@ -0,0 +1,64 @@
+package mandrillmail
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var _ Logger = slog.Default()
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(append([]interface{}{`DEBUG `, msg}, args...)...))
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(append([]interface{}{`ERROR `, msg}, args...)...))
+}
+
+func TestLogger_Redaction(t *testing.T) {
+
+	logger := new(recordingLogger)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"email":"secret.person@example.com","status":"sent","_id":"abc123"}]`))
+	}, WithLogger(logger))
+
+	_, err := m.SimpleMail(`from@example.com`, `secret.person@example.com`, `Test Mail`, `This is a test!`)
+	if err != nil {
+		t.Fatalf("SimpleMail failed with error : %s", err.Error())
+	}
+
+	if len(logger.lines) == 0 {
+		t.Fatal("Expected debug output")
+	}
+
+	output := strings.Join(logger.lines, "\n")
+	if strings.Contains(output, `secret.person`) || strings.Contains(output, `local-test-key`) {
+		t.Errorf("Log output leaked recipient or key :\n%s", output)
+	}
+
+	if !strings.Contains(output, `s***@example.com`) || !strings.Contains(output, `sent=1`) {
+		t.Errorf("Log output is missing the request or response summary :\n%s", output)
+	}
+}
+
+func TestRedactEmail(t *testing.T) {
+
+	tests := map[string]string{
+		`jane@example.com`: `j***@example.com`,
+		`@example.com`:     `***`,
+		`not-an-email`:     `***`,
+	}
+
+	for in, expected := range tests {
+		if out := redactEmail(in); out != expected {
+			t.Errorf("redactEmail(%q) = %q, expected %q", in, out, expected)
+		}
+	}
+}
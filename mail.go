@@ -1,7 +1,8 @@
 package mandrillmail
 
 import (
-	"errors"
+	"bytes"
+	"context"
 	"html/template"
 	"time"
 )
@@ -17,6 +18,15 @@ type Mailer interface {
 	SimpleMail(from, to, subject, body string) (*MailRecipientResponse, error)
 }
 
+// MailerContext is a Mailer whose calls can be cancelled, or given a deadline, through a context.Context. The
+// plain Mailer methods behave like their Context counterparts called with context.Background().
+type MailerContext interface {
+	Mailer
+	BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error)
+	TemplateMailContext(ctx context.Context, toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error)
+	SimpleMailContext(ctx context.Context, from, to, subject, body string) (*MailRecipientResponse, error)
+}
+
 type MailRecipientType string
 
 const (
@@ -36,22 +46,60 @@ const (
 	MAIL_MESSAGE_UNKNOWN   MailStatus = `unknown`
 )
 
+// MergeMode selects how per-recipient and global merge vars are applied to a MailMessage
+type MergeMode string
+
+const (
+	// MERGE_MODE_NONE renders the templates once with TemplateVars and sends the same content to everyone
+	MERGE_MODE_NONE MergeMode = ``
+	// MERGE_MODE_MAILCHIMP renders the templates once, then has the provider substitute *|NAME|* merge tags
+	MERGE_MODE_MAILCHIMP MergeMode = `mailchimp`
+	// MERGE_MODE_HANDLEBARS renders the templates once, then has the provider substitute {{name}} merge tags.
+	// Give the html/template different delimiters (see template.Delims) so it leaves them alone.
+	MERGE_MODE_HANDLEBARS MergeMode = `handlebars`
+	// MERGE_MODE_LOCAL renders the templates separately for each recipient, with TemplateVars,
+	// GlobalMergeVars and the recipient's MergeVars, and sends each recipient their own message
+	MERGE_MODE_LOCAL MergeMode = `local`
+)
+
+// RejectReason explains why a recipient was rejected. It is only set when the MailStatus is
+// MAIL_MESSAGE_REJECTED (or, for some providers, MAIL_MESSAGE_INVALID).
+type RejectReason string
+
+const (
+	MAIL_REJECT_HARD_BOUNCE     RejectReason = `hard-bounce`
+	MAIL_REJECT_SOFT_BOUNCE     RejectReason = `soft-bounce`
+	MAIL_REJECT_SPAM            RejectReason = `spam`
+	MAIL_REJECT_UNSUB           RejectReason = `unsub`
+	MAIL_REJECT_CUSTOM          RejectReason = `custom`
+	MAIL_REJECT_INVALID_SENDER  RejectReason = `invalid-sender`
+	MAIL_REJECT_INVALID         RejectReason = `invalid`
+	MAIL_REJECT_TEST_MODE_LIMIT RejectReason = `test-mode-limit`
+	MAIL_REJECT_UNSIGNED        RejectReason = `unsigned`
+	MAIL_REJECT_RULE            RejectReason = `rule`
+	// MAIL_REJECT_SUPPRESSED is set locally for recipients on a SuppressionStore, who are never sent
+	MAIL_REJECT_SUPPRESSED RejectReason = `suppressed`
+)
+
 type MailRecipient struct {
 	Name          string
 	Email         string
 	RecipientType MailRecipientType
 	// only really useful when tracking is on
 	Metadata map[string]string
+	// MergeVars are this recipient's values for merge tags (see MergeMode) or Mandrill template merge tags.
+	// Where a name is also in the message's global merge vars, the recipient's value wins.
+	MergeVars map[string]string
 }
 
 func (mr *MailRecipient) validate() error {
 
 	if mr.Email == `` {
-		return errors.New("The recipient email is required")
+		return &validationError{"The recipient email is required"}
 	}
 
 	if mr.RecipientType == `` {
-		return errors.New("The recipient type must be set")
+		return &validationError{"The recipient type must be set"}
 	}
 
 	return nil
@@ -82,31 +130,83 @@ type MailMessage struct {
 	MarkImportant bool
 	Tags          []string
 	Metadata      map[string]string
+	// GlobalMergeVars are merge var values shared by all recipients. They are ignored with MERGE_MODE_NONE.
+	GlobalMergeVars map[string]string
+	MergeMode       MergeMode
 }
 
 func (mm *MailMessage) validate() error {
 
 	if mm.HTMLTemplate == nil && mm.TextTemplate == nil {
-		return errors.New("Must set HTMLTemplate or TextTemplate for message")
+		return &validationError{"Must set HTMLTemplate or TextTemplate for message"}
 	}
 
 	if mm.Subject == `` {
-		return errors.New("Must set Subject for message")
+		return &validationError{"Must set Subject for message"}
 	}
 
 	if mm.From == nil {
-		return errors.New("Must set From for message")
+		return &validationError{"Must set From for message"}
+	}
+
+	switch mm.MergeMode {
+	case MERGE_MODE_NONE, MERGE_MODE_MAILCHIMP, MERGE_MODE_HANDLEBARS, MERGE_MODE_LOCAL:
+	default:
+		return &validationError{"MergeMode must be one of none, mailchimp, handlebars or local"}
 	}
 
 	return nil
 }
 
+// renderMessageContent executes the message templates with the supplied vars, returning the html and text
+// bodies. It is shared by every Mailer implementation so that a message renders the same wherever it is sent.
+func renderMessageContent(msg *MailMessage, vars map[string]string) (string, string, error) {
+
+	var (
+		htmlBuf = new(bytes.Buffer)
+		textBuf = new(bytes.Buffer)
+	)
+
+	// validation ensures that either the HTML or the Text template is set
+	if msg.HTMLTemplate != nil {
+		if err := msg.HTMLTemplate.Execute(htmlBuf, vars); err != nil {
+			return ``, ``, err
+		}
+	}
+
+	// if autotext is true, we take that as precedence over a non-nil text template
+	if msg.TextTemplate != nil && !msg.AutoText {
+		if err := msg.TextTemplate.Execute(textBuf, vars); err != nil {
+			return ``, ``, err
+		}
+	}
+
+	return htmlBuf.String(), textBuf.String(), nil
+}
+
+// recipientTemplateVars combines the message's TemplateVars and GlobalMergeVars with the recipient's MergeVars,
+// for rendering a message locally for that recipient. Later sources override earlier ones.
+func recipientTemplateVars(msg *MailMessage, rcpt *MailRecipient) map[string]string {
+
+	vars := make(map[string]string, len(msg.TemplateVars)+len(msg.GlobalMergeVars)+len(rcpt.MergeVars))
+	for _, src := range []map[string]string{msg.TemplateVars, msg.GlobalMergeVars, rcpt.MergeVars} {
+		for k, v := range src {
+			vars[k] = v
+		}
+	}
+
+	return vars
+}
+
 type SendParams struct {
 	SendAsync   bool
 	SendAt      *time.Time
 	IpPool      string
 	TrackOpens  bool
 	TrackClicks bool
+	// IdempotencyKey identifies a logical send. When the Mailer has a dedup store, a repeat send with the
+	// same key returns the original responses instead of sending again.
+	IdempotencyKey string
 }
 
 // DefaultSendMailParams returns a default set of SendParams that are good for TemplateMail and SimpleMail. You
@@ -124,8 +224,62 @@ func DefaultSendMailParams() *SendParams {
 }
 
 type MailRecipientResponse struct {
-	Id     string
-	Email  string
-	Status MailStatus
-	Error  string
+	Id           string
+	Email        string
+	Status       MailStatus
+	RejectReason RejectReason
+	Error        string
+	// Provider names the backend that handled the recipient, when sent through a FailoverMailer or
+	// SplitMailer. When they are nested, it names the innermost backend.
+	Provider string
+}
+
+// BulkResult buckets the responses of a send by outcome, so callers can decide what to retry or report
+// without switching on every MailStatus themselves.
+type BulkResult struct {
+	Sent      []MailRecipientResponse
+	Queued    []MailRecipientResponse
+	Scheduled []MailRecipientResponse
+	Rejected  []MailRecipientResponse
+	Invalid   []MailRecipientResponse
+	Unknown   []MailRecipientResponse
+}
+
+// NewBulkResult sorts the supplied responses into a BulkResult. Order within each bucket follows the input.
+func NewBulkResult(responses []MailRecipientResponse) *BulkResult {
+
+	r := new(BulkResult)
+	for _, v := range responses {
+		switch v.Status {
+		case MAIL_MESSAGE_SENT:
+			r.Sent = append(r.Sent, v)
+		case MAIL_MESSAGE_QUEUED:
+			r.Queued = append(r.Queued, v)
+		case MAIL_MESSAGE_SCHEDULED:
+			r.Scheduled = append(r.Scheduled, v)
+		case MAIL_MESSAGE_REJECTED:
+			r.Rejected = append(r.Rejected, v)
+		case MAIL_MESSAGE_INVALID:
+			r.Invalid = append(r.Invalid, v)
+		default:
+			r.Unknown = append(r.Unknown, v)
+		}
+	}
+
+	return r
+}
+
+// Accepted returns the number of recipients the provider accepted for delivery (sent, queued or scheduled)
+func (r *BulkResult) Accepted() int {
+	return len(r.Sent) + len(r.Queued) + len(r.Scheduled)
+}
+
+// Failed returns the recipients that were rejected or invalid. These will not be delivered as-is.
+func (r *BulkResult) Failed() []MailRecipientResponse {
+
+	failed := make([]MailRecipientResponse, 0, len(r.Rejected)+len(r.Invalid))
+	failed = append(failed, r.Rejected...)
+	failed = append(failed, r.Invalid...)
+
+	return failed
 }
@@ -2,13 +2,16 @@ package mandrillmail
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"github.com/davecgh/go-spew/spew"
 	"html/template"
+	"io"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,8 +20,32 @@ import (
 // todo better email and struct validation
 
 const (
-	MANDRILL_BASE_URL     = `https://mandrillapp.com/api/1.0`
-	MANDRILL_MESSAGE_PATH = `/messages/send.json`
+	MANDRILL_BASE_URL      = `https://mandrillapp.com/api/1.0`
+	MANDRILL_MESSAGE_PATH  = `/messages/send.json`
+	MANDRILL_TEMPLATE_PATH = `/messages/send-template.json`
+	MANDRILL_SEND_RAW_PATH = `/messages/send-raw.json`
+
+	MANDRILL_INFO_PATH               = `/messages/info.json`
+	MANDRILL_SEARCH_PATH             = `/messages/search.json`
+	MANDRILL_SEARCH_TIME_SERIES_PATH = `/messages/search-time-series.json`
+	MANDRILL_CONTENT_PATH            = `/messages/content.json`
+
+	MANDRILL_LIST_SCHEDULED_PATH   = `/messages/list-scheduled.json`
+	MANDRILL_CANCEL_SCHEDULED_PATH = `/messages/cancel-scheduled.json`
+	MANDRILL_RESCHEDULE_PATH       = `/messages/reschedule.json`
+
+	MANDRILL_REJECTS_ADD_PATH       = `/rejects/add.json`
+	MANDRILL_REJECTS_LIST_PATH      = `/rejects/list.json`
+	MANDRILL_REJECTS_DELETE_PATH    = `/rejects/delete.json`
+	MANDRILL_ALLOWLISTS_ADD_PATH    = `/allowlists/add.json`
+	MANDRILL_ALLOWLISTS_LIST_PATH   = `/allowlists/list.json`
+	MANDRILL_ALLOWLISTS_DELETE_PATH = `/allowlists/delete.json`
+)
+
+// Mandrill's date formats, always in UTC
+const (
+	mandrillDateFormat     = `2006-01-02`
+	mandrillDateTimeFormat = `2006-01-02 15:04:05`
 )
 
 type mandrillParams struct {
@@ -32,22 +59,22 @@ type mandrillParams struct {
 // validate checks required parameters
 func (mp *mandrillParams) validate() error {
 	if len(mp.Key) == 0 {
-		return errors.New("The message key identifies this transaction and must be set")
+		return &validationError{"The message key identifies this transaction and must be set"}
 	}
 
 	if mp.Message == nil {
-		return errors.New("The message must be set")
+		return &validationError{"The message must be set"}
 	}
 
 	return nil
 }
 
 type mandrillMessage struct {
-	Html                    string                      `json:"html"`
-	Text                    string                      `json:"text"`
-	Subject                 string                      `json:"subject"`
-	FromEmail               string                      `json:"from_email"`
-	FromName                string                      `json:"from_name"`
+	Html                    string                      `json:"html,omitempty"`
+	Text                    string                      `json:"text,omitempty"`
+	Subject                 string                      `json:"subject,omitempty"`
+	FromEmail               string                      `json:"from_email,omitempty"`
+	FromName                string                      `json:"from_name,omitempty"`
 	To                      []mandrillRecipient         `json:"to"`
 	Headers                 map[string]string           `json:"headers"`
 	MarkImportant           bool                        `json:"important"`
@@ -80,15 +107,15 @@ type mandrillMessage struct {
 func (mm *mandrillMessage) validate() error {
 
 	if mm.Html == `` && mm.Text == `` {
-		return errors.New("Must set Html or Text for message")
+		return &validationError{"Must set Html or Text for message"}
 	}
 
 	if mm.Subject == `` {
-		return errors.New("Must set Subject for message")
+		return &validationError{"Must set Subject for message"}
 	}
 
 	if mm.FromEmail == `` {
-		return errors.New("Must set FromEmail for message")
+		return &validationError{"Must set FromEmail for message"}
 	}
 
 	return nil
@@ -103,11 +130,11 @@ type mandrillRecipient struct {
 // validate checks required parameters
 func (mr *mandrillRecipient) validate() error {
 	if mr.Email == `` {
-		return errors.New("The recipient email is required")
+		return &validationError{"The recipient email is required"}
 	}
 
 	if mr.RecipientType == `` {
-		return errors.New("The recipient type must be set")
+		return &validationError{"The recipient type must be set"}
 	}
 
 	return nil
@@ -136,10 +163,10 @@ type mandrillAttachment struct {
 }
 
 type mandrillRecipientResponse struct {
-	Email        string     `json:"email"`
-	Status       MailStatus `json:"status"`
-	RejectReason string     `json:"reject_reason"`
-	Id           string     `json:"_id"`
+	Email        string       `json:"email"`
+	Status       MailStatus   `json:"status"`
+	RejectReason RejectReason `json:"reject_reason"`
+	Id           string       `json:"_id"`
 }
 
 type mandrillResponse struct {
@@ -156,17 +183,31 @@ type mandrillErrorResponse struct {
 }
 
 type mandrill struct {
-	key           string
-	domain        string
-	defaultSender *MailRecipient
-	client        *http.Client
-}
-
-var _ Mailer = new(mandrill)
+	key               string
+	domain            string
+	defaultSender     *MailRecipient
+	client            *http.Client
+	baseURL           string
+	paths             map[string]string
+	retryPolicy       *RetryPolicy
+	logger            Logger
+	batchSize         int
+	batchWorkers      int
+	limiter           *RateLimiter
+	rejects           *rejectCache
+	suppressions      SuppressionStore
+	suppressionPolicy SuppressionPolicy
+	dedup             IdempotencyStore
+	dedupTTL          time.Duration
+	inflightMu        sync.Mutex
+	inflight          map[string]chan struct{}
+}
+
+var _ MailerContext = new(mandrill)
 
 // Mandrill constructor. The signature is purposely kept minimal so it can be easily created
-// from a variety of application contexts
-func NewMandrill(apiKey string, domain string, sender *MailRecipient, client *http.Client) (*mandrill, error) {
+// from a variety of application contexts. Less common settings are supplied as MandrillOptions.
+func NewMandrill(apiKey string, domain string, sender *MailRecipient, client *http.Client, opts ...MandrillOption) (*mandrill, error) {
 
 	if len(apiKey) == 0 {
 		return nil, errors.New("API key is required")
@@ -184,16 +225,33 @@ func NewMandrill(apiKey string, domain string, sender *MailRecipient, client *ht
 		return nil, errors.New("must set non-nil http client")
 	}
 
-	return &mandrill{
+	m := &mandrill{
 		key:           apiKey,
 		domain:        domain,
 		defaultSender: sender,
 		client:        client,
-	}, nil
+		baseURL:       MANDRILL_BASE_URL,
+		paths:         map[string]string{},
+		logger:        nopLogger{},
+		inflight:      map[string]chan struct{}{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
 }
 
 // SimpleMail just sends a very simple email with the body you supply
 func (m *mandrill) SimpleMail(from, to, subject, body string) (*MailRecipientResponse, error) {
+	return m.SimpleMailContext(context.Background(), from, to, subject, body)
+}
+
+// SimpleMailContext is SimpleMail with a context that bounds the API call
+func (m *mandrill) SimpleMailContext(ctx context.Context, from, to, subject, body string) (*MailRecipientResponse, error) {
 
 	from = strings.TrimSpace(from)
 	to = strings.TrimSpace(to)
@@ -209,6 +267,10 @@ func (m *mandrill) SimpleMail(from, to, subject, body string) (*MailRecipientRes
 		return nil, errors.New("SimpleMail: Must specify subject;")
 	}
 
+	if r, err := m.checkSuppressed(to); r != nil || err != nil {
+		return r, err
+	}
+
 	msg := &mandrillMessage{
 		InlineCss:     false,
 		TrackClicks:   false,
@@ -225,7 +287,7 @@ func (m *mandrill) SimpleMail(from, to, subject, body string) (*MailRecipientRes
 	var params SendParams
 	mandrillParams := m.buildParams(&params, msg)
 
-	resp, err := m.send(mandrillParams)
+	resp, err := m.send(ctx, mandrillParams)
 	if err != nil {
 		return nil, err
 	}
@@ -239,6 +301,11 @@ func (m *mandrill) SimpleMail(from, to, subject, body string) (*MailRecipientRes
 
 // TemplateMail sends a templated email to a single recipient. Interpolates the supplied vars into the supplied template.
 func (m *mandrill) TemplateMail(toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+	return m.TemplateMailContext(context.Background(), toEmail, subject, template, vars)
+}
+
+// TemplateMailContext is TemplateMail with a context that bounds the API call
+func (m *mandrill) TemplateMailContext(ctx context.Context, toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
 
 	if toEmail == `` {
 		return nil, errors.New("TemplateMail: Must specify destination email address;")
@@ -246,6 +313,10 @@ func (m *mandrill) TemplateMail(toEmail string, subject string, template *templa
 		return nil, errors.New("TemplateMail: Must specify template;")
 	}
 
+	if r, err := m.checkSuppressed(toEmail); r != nil || err != nil {
+		return r, err
+	}
+
 	recipients := []MailRecipient{
 		{
 			Name:          ``,
@@ -260,7 +331,7 @@ func (m *mandrill) TemplateMail(toEmail string, subject string, template *templa
 		Subject:      subject,
 	}
 
-	msg, err := m.buildMessage(recipients, message)
+	msg, err := m.buildMessage(recipients, message, message.TemplateVars)
 	if err != nil {
 		return nil, err
 	}
@@ -271,7 +342,7 @@ func (m *mandrill) TemplateMail(toEmail string, subject string, template *templa
 		return nil, err
 	}
 
-	resp, err := m.send(mandrillParams)
+	resp, err := m.send(ctx, mandrillParams)
 	if err != nil {
 		return nil, err
 	}
@@ -286,52 +357,129 @@ func (m *mandrill) TemplateMail(toEmail string, subject string, template *templa
 // BulkMail sends an email to potentially many recipients. Allows the sender to set SendParams to track opens
 // and clicks and other settings.
 func (m *mandrill) BulkMail(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	return m.BulkMailContext(context.Background(), recipients, message, params)
+}
 
-	err := m.validateMessageAndRecipients(recipients, message)
+// BulkMailContext is BulkMail with a context that bounds the API call
+func (m *mandrill) BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	send, suppressed, err := m.validateMessageAndRecipients(recipients, message)
 	if err != nil {
 		return nil, err
 	}
 
-	msg, err := m.buildMessage(recipients, message)
-	if err != nil {
-		return nil, err
+	return m.idempotent(ctx, params, func() ([]MailRecipientResponse, error) {
+
+		if len(send) == 0 {
+			return suppressed, nil
+		}
+
+		var (
+			resp []MailRecipientResponse
+			err  error
+		)
+		if m.batchSize > 0 && len(send) > m.batchSize {
+			resp, err = m.bulkMailBatched(ctx, send, message, params)
+		} else {
+			resp, err = m.bulkMailChunk(ctx, send, message, params)
+		}
+
+		return withDecided(recipients, send, resp, suppressed, err)
+	})
+}
+
+// bulkMailChunk sends message to recipients in a single request, or one request per recipient for
+// MERGE_MODE_LOCAL
+func (m *mandrill) bulkMailChunk(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	send, skipped := m.skipRejected(ctx, recipients)
+	if len(send) == 0 {
+		return skipped, nil
 	}
-	msg.TrackOpens = params.TrackOpens
-	msg.TrackClicks = params.TrackClicks
 
-	mandrillParams := m.buildParams(params, msg)
-	if err := mandrillParams.validate(); err != nil {
-		return nil, err
+	var (
+		resp []MailRecipientResponse
+		err  error
+	)
+	if message.MergeMode == MERGE_MODE_LOCAL {
+		resp, err = m.bulkMailLocal(ctx, send, message, params)
+	} else {
+		var msg *mandrillMessage
+		if msg, err = m.buildMessage(send, message, message.TemplateVars); err == nil {
+			resp, err = m.sendMessage(ctx, msg, params)
+		}
 	}
 
-	resp, err := m.send(mandrillParams)
-	if err != nil {
-		return nil, err
+	return withDecided(recipients, send, resp, skipped, err)
+}
+
+// bulkMailLocal renders the message separately for each recipient, using its own merge vars, and sends each
+// copy on its own. Because every recipient gets a separate message, Cc and Bcc recipients are not visible
+// to each other. If a copy fails after others were sent, the remaining recipients are returned in an
+// *UnsentError.
+func (m *mandrill) bulkMailLocal(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	resp := make([]MailRecipientResponse, 0, len(recipients))
+	for i := range recipients {
+
+		rcpt := recipients[i : i+1]
+		msg, err := m.buildMessage(rcpt, message, recipientTemplateVars(message, &recipients[i]))
+		if err == nil {
+			var r []MailRecipientResponse
+			if r, err = m.sendMessage(ctx, msg, params); err == nil {
+				resp = append(resp, r...)
+				continue
+			}
+		}
+
+		return unsentAfter(resp, recipients[i:], err)
 	}
 
+	return resp, nil
+}
+
+// unsentAfter ends a send that failed with err after the recipients in resp were sent. Nothing is returned
+// but err if nothing was sent; otherwise unsent get MAIL_MESSAGE_UNKNOWN responses and an *UnsentError.
+func unsentAfter(resp []MailRecipientResponse, unsent []MailRecipient, err error) ([]MailRecipientResponse, error) {
+
 	if len(resp) == 0 {
 		return nil, err
 	}
 
-	return resp, nil
+	return append(resp, unsentResponses(unsent, err)...), &UnsentError{Recipients: unsent, Err: err}
 }
 
-// validateMessageAndRecipients
-func (m *mandrill) validateMessageAndRecipients(recipients []MailRecipient, message *MailMessage) error {
+// sendMessage applies the send params to a built message and submits it
+func (m *mandrill) sendMessage(ctx context.Context, msg *mandrillMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	msg.TrackOpens = params.TrackOpens
+	msg.TrackClicks = params.TrackClicks
+
+	mandrillParams := m.buildParams(params, msg)
+	if err := mandrillParams.validate(); err != nil {
+		return nil, err
+	}
+
+	return m.send(ctx, mandrillParams)
+}
+
+// validateMessageAndRecipients checks the message and recipients, and then the recipients against any
+// SuppressionStore. It returns the recipients to send to and responses for those that were suppressed.
+func (m *mandrill) validateMessageAndRecipients(recipients []MailRecipient, message *MailMessage) ([]MailRecipient, []MailRecipientResponse, error) {
 
 	var e error
 
 	for _, v := range recipients {
 		if e = v.validate(); e != nil {
-			return e
+			return nil, nil, e
 		}
 	}
 
 	if e = message.validate(); e != nil {
-		return e
+		return nil, nil, e
 	}
 
-	return nil
+	return m.checkSuppressions(recipients)
 }
 
 // defaultMessage sets some common defaults for a mandrillMessage, but is not sufficient for sending.
@@ -349,31 +497,11 @@ func (m *mandrill) defaultMessage() *mandrillMessage {
 
 // buildMessageContent builds the content of the email from the supplied template.
 func (m *mandrill) buildMessageContent(msg *MailMessage, vars map[string]string) (string, string, error) {
-
-	var (
-		htmlBuf = new(bytes.Buffer)
-		textBuf = new(bytes.Buffer)
-	)
-
-	// validation ensures that either the HTML or the Text template is set
-	if msg.HTMLTemplate != nil {
-		if err := msg.HTMLTemplate.Execute(htmlBuf, vars); err != nil {
-			return ``, ``, err
-		}
-	}
-
-	// if autotext is true, we take that as precedence over a non-nil text template
-	if msg.TextTemplate != nil && !msg.AutoText {
-		if err := msg.HTMLTemplate.Execute(textBuf, vars); err != nil {
-			return ``, ``, err
-		}
-	}
-
-	return htmlBuf.String(), textBuf.String(), nil
+	return renderMessageContent(msg, vars)
 }
 
-// buildMessage builds a Mandrill-formatted message for sending
-func (m *mandrill) buildMessage(recipients []MailRecipient, message *MailMessage) (*mandrillMessage, error) {
+// buildMessage builds a Mandrill-formatted message for sending, rendering its templates with vars
+func (m *mandrill) buildMessage(recipients []MailRecipient, message *MailMessage, vars map[string]string) (*mandrillMessage, error) {
 
 	msg := m.defaultMessage()
 
@@ -388,59 +516,77 @@ func (m *mandrill) buildMessage(recipients []MailRecipient, message *MailMessage
 	msg.Tags = message.Tags
 
 	// set email content
-	if err := m.setMessageContent(message, msg); err != nil {
+	if err := m.setMessageContent(message, vars, msg); err != nil {
 		return nil, err
 	}
 
+	// merge tags are substituted by Mandrill
+	if message.MergeMode == MERGE_MODE_MAILCHIMP || message.MergeMode == MERGE_MODE_HANDLEBARS {
+		msg.Merge = true
+		msg.MergeLanguage = string(message.MergeMode)
+		msg.GlobalMergeVars = buildMergeVars(message.GlobalMergeVars)
+		msg.MergeVars = buildRecipientMergeVars(recipients)
+	}
+
 	// set recipients
 	if err := m.setMessageRecipients(recipients, message, msg); err != nil {
 		return nil, err
 	}
 
-	// attachments
-	var mattach []mandrillAttachment
-	if len(message.Attachments) > 0 {
-		mattach = make([]mandrillAttachment, len(message.Attachments), len(message.Attachments))
-		for i := range message.Attachments {
-			mattach[i] = m.mailAttachmentToMandrillAttachment(message.Attachments[i])
-		}
-		msg.Attachments = mattach
-	}
-
-	// images
-	if len(message.Images) > 0 {
-		mattach = make([]mandrillAttachment, len(message.Images), len(message.Images))
-		for i := range message.Images {
-			mattach[i] = m.mailAttachmentToMandrillAttachment(message.Images[i])
-		}
-		msg.Images = mattach
-	}
+	// attachments & images
+	m.setMessageAttachments(message.Attachments, message.Images, msg)
 
 	return msg, nil
 }
 
 // setMessageFrom set the "from" data and Reply-To header for the supplied mandrillMessage
 func (m *mandrill) setMessageFrom(src *MailMessage, dest *mandrillMessage) {
+	m.setMessageSender(src.From, src.ReplyTo, dest)
+}
 
-	if len(dest.FromEmail) > 0 {
-		dest.FromEmail = src.From.Email
-		dest.FromName = src.From.Name
+// setMessageSender sets the "from" data, falling back to the default sender, and the Reply-To header
+func (m *mandrill) setMessageSender(from *MailRecipient, replyTo string, dest *mandrillMessage) {
+
+	if from != nil && len(from.Email) > 0 {
+		dest.FromEmail = from.Email
+		dest.FromName = from.Name
 	} else {
 		dest.FromEmail = m.defaultSender.Email
 		dest.FromName = m.defaultSender.Name
 	}
 
-	if len(src.ReplyTo) > 0 {
+	if len(replyTo) > 0 {
 		dest.Headers = map[string]string{
-			`Reply-To`: src.ReplyTo,
+			`Reply-To`: replyTo,
+		}
+	}
+}
+
+// setMessageAttachments converts and sets the attachments and inline images for the supplied mandrillMessage
+func (m *mandrill) setMessageAttachments(attachments []EmailAttachment, images []EmailAttachment, dest *mandrillMessage) {
+
+	var mattach []mandrillAttachment
+	if len(attachments) > 0 {
+		mattach = make([]mandrillAttachment, len(attachments), len(attachments))
+		for i := range attachments {
+			mattach[i] = m.mailAttachmentToMandrillAttachment(attachments[i])
+		}
+		dest.Attachments = mattach
+	}
+
+	if len(images) > 0 {
+		mattach = make([]mandrillAttachment, len(images), len(images))
+		for i := range images {
+			mattach[i] = m.mailAttachmentToMandrillAttachment(images[i])
 		}
+		dest.Images = mattach
 	}
 }
 
 // setMessageContent set the html or text content for the supplied mandrillMessage
-func (m *mandrill) setMessageContent(src *MailMessage, dest *mandrillMessage) error {
+func (m *mandrill) setMessageContent(src *MailMessage, vars map[string]string, dest *mandrillMessage) error {
 
-	html, text, err := m.buildMessageContent(src, src.TemplateVars)
+	html, text, err := m.buildMessageContent(src, vars)
 	if err != nil {
 		return err
 	}
@@ -524,55 +670,141 @@ func (m *mandrill) buildParams(params *SendParams, msg *mandrillMessage) *mandri
 		IpPool:  params.IpPool,
 	}
 
+	// stamp the idempotency key so duplicates can be traced, without changing the caller's metadata
+	if params.IdempotencyKey != `` {
+		meta := make(map[string]string, len(msg.Metadata)+1)
+		for k, v := range msg.Metadata {
+			meta[k] = v
+		}
+		meta[IDEMPOTENCY_METADATA_KEY] = params.IdempotencyKey
+		msg.Metadata = meta
+	}
+
+	// Mandrill expects send_at in UTC. The caller's params are left untouched, since they may be reused
+	// for several sends.
 	if !(params.SendAt == nil || params.SendAt.IsZero()) {
+		p.SendAtTxt = params.SendAt.UTC().Format(mandrillDateTimeFormat)
+	}
 
-		_, offset := params.SendAt.Zone()
+	return p
+}
 
-		// adjust for timezone
-		if offset != 0 {
-			seconds := params.SendAt.Unix()
-			seconds = seconds - int64(offset)
+// send submits the email to Mandrill
+func (m *mandrill) send(ctx context.Context, params *mandrillParams) ([]MailRecipientResponse, error) {
+	return m.deliver(ctx, MANDRILL_MESSAGE_PATH, params, params)
+}
 
-			ut := time.Unix(seconds, 0)
-			params.SendAt = &ut
-		}
+// deliver posts body to one of the message sending endpoints and returns the per-recipient results. params
+// holds the message and send settings common to all of those endpoints, and is usually embedded in body.
+func (m *mandrill) deliver(ctx context.Context, endpoint string, body interface{}, params *mandrillParams) ([]MailRecipientResponse, error) {
 
-		p.SendAtTxt = params.SendAt.Format(`2006-01-02 15:04:05`)
+	var mandrillResponse = new(mandrillResponse)
+
+	m.logger.Debug(`mandrill: sending message`,
+		`endpoint`, endpoint,
+		`key`, redactKey(params.Key),
+		`recipients`, redactRecipients(params.Message.To),
+		`async`, params.Async,
+		`send_at`, params.SendAtTxt)
+
+	err := m.callWith(ctx, endpoint, body, &mandrillResponse.response, &sendOptions{recipients: len(params.Message.To)})
+	if err != nil {
+		return nil, err
 	}
 
-	return p
+	resp, err := m.handleApiSuccess(mandrillResponse)
+	if err == nil {
+		m.logger.Debug(`mandrill: message sent`, `statuses`, summarizeStatuses(resp))
+	}
+
+	return resp, err
 }
 
-// send submits the email to Mandrill
-func (m *mandrill) send(params *mandrillParams) ([]MailRecipientResponse, error) {
+// call posts the JSON-encoded params to the supplied endpoint and decodes a successful response into result.
+// Non-2xx responses are returned as an *APIError. The request is abandoned if ctx is done. Failed attempts
+// are retried according to the client's RetryPolicy, but never once a 2xx response has been received.
+func (m *mandrill) call(ctx context.Context, endpoint string, params interface{}, result interface{}) error {
+	return m.callWith(ctx, endpoint, params, result, nil)
+}
+
+// sendOptions describes a call to one of the message sending endpoints
+type sendOptions struct {
+	// recipients is charged to the client's RateLimiter on every attempt, retries included
+	recipients int
+}
+
+// callWith is call for the message sending endpoints, which send is set for
+func (m *mandrill) callWith(ctx context.Context, endpoint string, params interface{}, result interface{}, send *sendOptions) error {
 
-	//spew.Dump(params)
 	sendJson, err := json.Marshal(params)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	url := MANDRILL_BASE_URL + MANDRILL_MESSAGE_PATH
-	reader := strings.NewReader(string(sendJson))
+	url := m.endpointURL(endpoint)
+
+	return m.retryPolicy.do(ctx, func() (bool, error) {
+		if send != nil {
+			if err := m.limiter.Wait(ctx, send.recipients); err != nil {
+				return false, err
+			}
+		}
+		return m.attempt(ctx, url, sendJson, result, send)
+	})
+}
 
-	response, err := m.client.Post(url, `application/json`, reader)
+// attempt makes a single POST to the API. The returned bool reports whether the failure is transient and the
+// request may safely be retried.
+func (m *mandrill) attempt(ctx context.Context, url string, sendJson []byte, result interface{}, send *sendOptions) (bool, error) {
+
+	// the transport reports when the whole request has been written, from its own goroutine
+	var wrote atomic.Bool
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			wrote.Store(true)
+		},
+	}
+
+	request, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodPost, url, bytes.NewReader(sendJson))
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	request.Header.Set(`Content-Type`, `application/json`)
+
+	start := time.Now()
+	response, err := m.client.Do(request)
+	if err != nil {
+		m.logger.Error(`mandrill: request failed`, `url`, url, `error`, err)
+		// network errors are transient unless the caller gave up, or a send failed after its request was
+		// written. Mandrill may have accepted that send, and nothing would stop a retry delivering it again.
+		if send != nil && wrote.Load() {
+			return false, err
+		}
+		return ctx.Err() == nil, err
 	}
 
 	defer response.Body.Close()
-	decoder := json.NewDecoder(response.Body)
 
-	var mandrillResponse = new(mandrillResponse)
+	m.logger.Debug(`mandrill: received response`,
+		`url`, url,
+		`status`, response.StatusCode,
+		`duration`, time.Since(start))
 
-	err = decoder.Decode(&mandrillResponse.response)
-	spew.Dump(mandrillResponse)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		err = m.handleApiError(response)
+		m.logger.Error(`mandrill: api error`, `url`, url, `error`, err)
+		return isRetryableError(err), err
+	}
+
+	decoder := json.NewDecoder(response.Body)
+
+	err = decoder.Decode(result)
 	if err != nil {
-		fmt.Printf("ERR: %s\n", err.Error())
-		return nil, err
+		m.logger.Error(`mandrill: failed to decode response`, `url`, url, `error`, err)
+		return false, err
 	}
 
-	return m.handleApiSuccess(mandrillResponse)
+	return false, nil
 }
 
 // handleApiSuccess created a structured response for a successful mandrill call. Note that the
@@ -586,41 +818,51 @@ func (m *mandrill) handleApiSuccess(response *mandrillResponse) ([]MailRecipient
 	for i, v := range response.response {
 
 		switch v.Status {
-		case MAIL_MESSAGE_SENT:
-			status = MAIL_MESSAGE_SENT
-		case MAIL_MESSAGE_QUEUED:
-			status = MAIL_MESSAGE_SENT
-		case MAIL_MESSAGE_SCHEDULED:
-			status = MAIL_MESSAGE_SENT
-		case MAIL_MESSAGE_REJECTED:
-			status = MAIL_MESSAGE_SENT
-		case MAIL_MESSAGE_INVALID:
-			status = MAIL_MESSAGE_SENT
+		case MAIL_MESSAGE_SENT, MAIL_MESSAGE_QUEUED, MAIL_MESSAGE_SCHEDULED, MAIL_MESSAGE_REJECTED, MAIL_MESSAGE_INVALID:
+			status = v.Status
 		default:
 			status = MAIL_MESSAGE_UNKNOWN
 		}
 
 		resp[i] = MailRecipientResponse{
-			Id:     v.Id,
-			Email:  v.Email,
-			Status: status,
-			Error:  v.RejectReason,
+			Id:           v.Id,
+			Email:        v.Email,
+			Status:       status,
+			RejectReason: v.RejectReason,
+			Error:        string(v.RejectReason),
 		}
 	}
 
 	return resp, nil
 }
 
-// handleApiError formats proper message for api call that failed
-func (m *mandrill) handleApiError(response *http.Response, params *mandrillParams) error {
+// handleApiError converts a failed api call into an *APIError. Mandrill normally describes the failure in a
+// JSON body; if the body can't be decoded, the raw text is used as the message instead.
+func (m *mandrill) handleApiError(response *http.Response) error {
 
-	var e mandrillErrorResponse
-	decoder := json.NewDecoder(response.Body)
-	err := decoder.Decode(&e)
+	body, err := io.ReadAll(response.Body)
 	if err != nil {
 		return err
 	}
 
-	return fmt.Errorf("Email, sent to %d recipients starting with %s, failed : %s\n",
-		len(params.Message.To), params.Message.To[0].Email, e.Message)
+	var e mandrillErrorResponse
+	if err := json.Unmarshal(body, &e); err != nil || e.Message == `` {
+		return &APIError{
+			HTTPStatus: response.StatusCode,
+			Message:    strings.TrimSpace(string(body)),
+		}
+	}
+
+	return newAPIError(response.StatusCode, &e)
+}
+
+// parseMandrillTime parses one of Mandrill's UTC timestamps. Mandrill leaves optional timestamps empty, and
+// those are returned as the zero time.
+func parseMandrillTime(s string) (time.Time, error) {
+
+	if s == `` {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(mandrillDateTimeFormat, s)
 }
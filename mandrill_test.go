@@ -1,9 +1,12 @@
 package mandrillmail
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"html/template"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -154,12 +157,43 @@ func initData() (*mandrill, error) {
 
 	m, err := NewMandrill(mandrillTestKey, "globio.co", sender, new(http.Client))
 	if err != nil {
-		errors.New("Failed to create new Mandrill instance")
+		return nil, errors.New("Failed to create new Mandrill instance")
 	}
 
 	return m, nil
 }
 
+// initLocalData creates a mandrill instance that talks to a local stand-in server rather than the real API
+func initLocalData(t *testing.T, handler http.HandlerFunc, opts ...MandrillOption) *mandrill {
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	sender := &MailRecipient{
+		Name:  `Mandrill From Name`,
+		Email: `sender@example.com`,
+	}
+
+	opts = append([]MandrillOption{WithBaseURL(server.URL)}, opts...)
+	m, err := NewMandrill(`local-test-key`, `example.com`, sender, server.Client(), opts...)
+	if err != nil {
+		t.Fatalf("Failed to create local Mandrill instance : %s", err.Error())
+	}
+
+	return m
+}
+
+// newTestMessage returns a minimal message that can be sent. Tests that care about its content set the
+// fields they need on it.
+func newTestMessage() *MailMessage {
+
+	return &MailMessage{
+		HTMLTemplate: template.Must(template.New(`test`).Parse(`Hello`)),
+		Subject:      `Test`,
+		From:         &MailRecipient{Email: `from@example.com`},
+	}
+}
+
 func TestBulk_Mail(t *testing.T) {
 
 	m, err := initData()
@@ -232,3 +266,268 @@ func TestMandrill_SimpleMail(t *testing.T) {
 		t.Errorf(`SimpleMail to %s failed with error: %s`, response.Email, response.Error)
 	}
 }
+
+func TestMandrill_BaseURL(t *testing.T) {
+
+	var path string
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set(`Content-Type`, `application/json`)
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent","_id":"abc123"}]`))
+	}, WithEndpointPath(MANDRILL_MESSAGE_PATH, `custom/send.json`))
+
+	response, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`)
+	if err != nil {
+		t.Fatalf("SimpleMail failed with error : %s", err.Error())
+	}
+
+	if path != `/custom/send.json` {
+		t.Errorf("Expected request to /custom/send.json, got %s", path)
+	}
+
+	if response.Id != `abc123` || response.Status != MAIL_MESSAGE_SENT {
+		t.Errorf("Unexpected response : %+v", response)
+	}
+}
+
+func TestNewMandrill_InvalidOption(t *testing.T) {
+
+	sender := &MailRecipient{Email: `sender@example.com`}
+	_, err := NewMandrill(`key`, `example.com`, sender, new(http.Client), WithBaseURL(` `))
+	if err == nil {
+		t.Error("Expected an error for an empty base URL")
+	}
+}
+
+func TestMandrill_APIError(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `application/json`)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":"error","code":-1,"name":"Invalid_Key","message":"Invalid API key"}`))
+	})
+
+	_, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`)
+	if err == nil {
+		t.Fatal("Expected an error for an invalid key")
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an *APIError, got %T", err)
+	}
+
+	if apiErr.HTTPStatus != http.StatusInternalServerError || apiErr.Code != -1 || apiErr.Message != `Invalid API key` {
+		t.Errorf("Unexpected APIError : %+v", apiErr)
+	}
+
+	if !IsInvalidKey(err) || IsValidationError(err) {
+		t.Errorf("Error helpers misclassified %s", err.Error())
+	}
+}
+
+func TestMandrill_HandleApiSuccess(t *testing.T) {
+
+	m := &mandrill{}
+	resp, err := m.handleApiSuccess(&mandrillResponse{
+		response: []mandrillRecipientResponse{
+			{Email: `a@example.com`, Status: MAIL_MESSAGE_SENT},
+			{Email: `b@example.com`, Status: MAIL_MESSAGE_QUEUED},
+			{Email: `c@example.com`, Status: MAIL_MESSAGE_REJECTED, RejectReason: MAIL_REJECT_HARD_BOUNCE},
+			{Email: `d@example.com`, Status: MAIL_MESSAGE_INVALID},
+			{Email: `e@example.com`, Status: `bogus`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []MailStatus{MAIL_MESSAGE_SENT, MAIL_MESSAGE_QUEUED, MAIL_MESSAGE_REJECTED, MAIL_MESSAGE_INVALID, MAIL_MESSAGE_UNKNOWN}
+	for i, v := range resp {
+		if v.Status != expected[i] {
+			t.Errorf("Expected status %s for %s, got %s", expected[i], v.Email, v.Status)
+		}
+	}
+
+	if resp[2].RejectReason != MAIL_REJECT_HARD_BOUNCE {
+		t.Errorf("Expected reject reason %s, got %s", MAIL_REJECT_HARD_BOUNCE, resp[2].RejectReason)
+	}
+
+	result := NewBulkResult(resp)
+	if result.Accepted() != 2 || len(result.Failed()) != 2 || len(result.Unknown) != 1 {
+		t.Errorf("Unexpected BulkResult : %+v", result)
+	}
+}
+
+func TestMandrill_SimpleMailContext(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Request should not be sent with a cancelled context")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := m.SimpleMailContext(ctx, `from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMandrill_BulkMailMergeModes(t *testing.T) {
+
+	var bodies []mandrillParams
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+		bodies = append(bodies, p)
+
+		resp := make([]mandrillRecipientResponse, len(p.Message.To))
+		for i, v := range p.Message.To {
+			resp[i] = mandrillRecipientResponse{Email: v.Email, Status: MAIL_MESSAGE_SENT}
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`Name`: `Ann`}},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+	}
+	message := &MailMessage{
+		HTMLTemplate:    template.Must(template.New(`merge_test`).Parse(`Hi {{.Name}} from {{.Company}} *|FNAME|*`)),
+		TemplateVars:    map[string]string{`Name`: `there`},
+		GlobalMergeVars: map[string]string{`Company`: `Acme`},
+		Subject:         `Merge Test`,
+		From:            &MailRecipient{Email: `from@example.com`},
+		MergeMode:       MERGE_MODE_LOCAL,
+	}
+
+	resp, err := m.BulkMail(recipients, message, &SendParams{})
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if len(bodies) != 2 || len(resp) != 2 || resp[1].Email != `b@example.com` {
+		t.Fatalf("Expected one request per recipient, got %d requests and responses %+v", len(bodies), resp)
+	}
+
+	if bodies[0].Message.Html != `Hi Ann from Acme *|FNAME|*` || bodies[1].Message.Html != `Hi there from Acme *|FNAME|*` {
+		t.Errorf("Unexpected local rendering : %q, %q", bodies[0].Message.Html, bodies[1].Message.Html)
+	}
+
+	bodies = nil
+	message.MergeMode = MERGE_MODE_MAILCHIMP
+	if _, err := m.BulkMail(recipients, message, &SendParams{}); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	sent := bodies[0].Message
+	if len(bodies) != 1 || !sent.Merge || sent.MergeLanguage != `mailchimp` || len(sent.GlobalMergeVars) != 1 ||
+		len(sent.MergeVars) != 1 || sent.MergeVars[0].Rcpt != `a@example.com` {
+		t.Errorf("Unexpected merge settings : %+v", sent)
+	}
+}
+
+func TestBulk_MailFrom(t *testing.T) {
+
+	var body mandrillParams
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent"}]`))
+	})
+
+	message := &MailMessage{
+		TextTemplate: template.Must(template.New(`from_test`).Parse(`Hello`)),
+		Subject:      `From Test`,
+		From:         &MailRecipient{Name: `Billing`, Email: `billing@example.com`},
+		ReplyTo:      `accounts@example.com`,
+	}
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+
+	if _, err := m.BulkMail(recipients, message, &SendParams{}); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	// the message's sender is used rather than the client's default
+	if body.Message.FromEmail != `billing@example.com` || body.Message.FromName != `Billing` ||
+		body.Message.Headers[`Reply-To`] != `accounts@example.com` {
+		t.Errorf("Unexpected sender : %+v", body.Message)
+	}
+}
+
+func TestBulk_MailTextTemplate(t *testing.T) {
+
+	var body mandrillParams
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent"}]`))
+	})
+
+	message := &MailMessage{
+		HTMLTemplate: template.Must(template.New(`text_test_html`).Parse(`<p>Hi {{.Name}}</p>`)),
+		TextTemplate: template.Must(template.New(`text_test_text`).Parse(`Hi {{.Name}}`)),
+		TemplateVars: map[string]string{`Name`: `Ann`},
+		Subject:      `Text Test`,
+		From:         &MailRecipient{Email: `from@example.com`},
+	}
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+
+	if _, err := m.BulkMail(recipients, message, &SendParams{}); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	// the text body comes from the text template, not the HTML one
+	if body.Message.Html != `<p>Hi Ann</p>` || body.Message.Text != `Hi Ann` {
+		t.Errorf("Unexpected content %q and %q", body.Message.Html, body.Message.Text)
+	}
+
+	// a text-only message needs no HTML template
+	message.HTMLTemplate = nil
+	body = mandrillParams{}
+	if _, err := m.BulkMail(recipients, message, &SendParams{}); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+	if body.Message.Html != `` || body.Message.Text != `Hi Ann` {
+		t.Errorf("Unexpected text-only content %q and %q", body.Message.Html, body.Message.Text)
+	}
+}
+
+func TestMandrill_BulkMailLocalPartialFailure(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+
+		if email := p.Message.To[0].Email; email == `b@example.com` {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"error","code":-99,"name":"ServiceUnavailable","message":"Try again"}`))
+		} else {
+			w.Write([]byte(`[{"email":"` + email + `","status":"sent","_id":"abc123"}]`))
+		}
+	})
+
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+		{Email: `c@example.com`, RecipientType: MAIL_TO},
+	}
+	message := newTestMessage()
+	message.MergeMode = MERGE_MODE_LOCAL
+
+	// a@ was sent before b@ failed, so only b@ and c@ are left to retry
+	resp, err := m.BulkMail(recipients, message, &SendParams{})
+
+	var unsentErr *UnsentError
+	if !errors.As(err, &unsentErr) || len(unsentErr.Recipients) != 2 || unsentErr.Recipients[0].Email != `b@example.com` {
+		t.Fatalf("Expected an UnsentError for the last two recipients, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Name != MANDRILL_ERROR_SERVICE_UNAVAILABLE {
+		t.Errorf("Expected the API error to be exposed, got %v", err)
+	}
+
+	if len(resp) != 3 || resp[0].Status != MAIL_MESSAGE_SENT || resp[1].Status != MAIL_MESSAGE_UNKNOWN ||
+		resp[2].Email != `c@example.com` || resp[2].Status != MAIL_MESSAGE_UNKNOWN {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+}
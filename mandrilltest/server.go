@@ -0,0 +1,338 @@
+// Package mandrilltest provides a local emulator of the Mandrill API for offline integration tests. Point the
+// mandrillmail client at it with WithBaseURL(server.URL). It also provides a minimal SMTP server for testing
+// the SMTP mailer.
+package mandrilltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ERROR_INVALID_KEY      = `Invalid_Key`
+	ERROR_VALIDATION       = `ValidationError`
+	ERROR_UNKNOWN_TEMPLATE = `Unknown_Template`
+)
+
+// Request is a call received by the Server. Body holds the raw JSON so that any endpoint can be inspected.
+type Request struct {
+	Path string
+	Body []byte
+}
+
+// Server is an httptest.Server implementing the Mandrill endpoints used by the mandrillmail client. Unless
+// scripted otherwise it accepts every valid request and reports every recipient as sent.
+type Server struct {
+	*httptest.Server
+	// Key is the only API key the server accepts
+	Key string
+
+	mu        sync.Mutex
+	mux       *http.ServeMux
+	requests  []Request
+	statuses  map[string]RecipientResponse
+	templates map[string]bool
+	failures  []scriptedFailure
+	latency   time.Duration
+	nextId    int
+}
+
+type scriptedFailure struct {
+	httpStatus int
+	body       ErrorResponse
+}
+
+// NewServer starts an emulator that accepts the supplied API key. Call Close when done.
+func NewServer(key string) *Server {
+
+	s := &Server{
+		Key:       key,
+		mux:       http.NewServeMux(),
+		statuses:  map[string]RecipientResponse{},
+		templates: map[string]bool{},
+	}
+	s.mux.HandleFunc(`/messages/send.json`, s.handleSend)
+	s.mux.HandleFunc(`/messages/send-template.json`, s.handleSendTemplate)
+	s.mux.HandleFunc(`/messages/send-raw.json`, s.handleSendRaw)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// SetRecipientStatus scripts the status, and optional reject reason, reported for email by every later send
+func (s *Server) SetRecipientStatus(email, status, rejectReason string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statuses[strings.ToLower(email)] = RecipientResponse{
+		Status:       status,
+		RejectReason: rejectReason,
+	}
+}
+
+// AddTemplate registers a stored template. Sends naming any other template fail with Unknown_Template.
+func (s *Server) AddTemplate(name string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.templates[name] = true
+}
+
+// SetLatency delays every response by d, or until the client gives up
+func (s *Server) SetLatency(d time.Duration) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// FailNext makes the next n calls, to any endpoint, fail with the supplied HTTP status and error body
+func (s *Server) FailNext(n int, httpStatus int, e ErrorResponse) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.Status == `` {
+		e.Status = `error`
+	}
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, scriptedFailure{httpStatus: httpStatus, body: e})
+	}
+}
+
+// Requests returns every call received so far, oldest first, including ones that failed
+func (s *Server) Requests() []Request {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// SentParams decodes the body of every /messages/send.json call received so far
+func (s *Server) SentParams() []Params {
+
+	var params []Params
+	for _, r := range s.Requests() {
+		if r.Path != `/messages/send.json` {
+			continue
+		}
+		var p Params
+		if err := json.Unmarshal(r.Body, &p); err == nil {
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+// SentTemplateParams decodes the body of every /messages/send-template.json call received so far
+func (s *Server) SentTemplateParams() []TemplateParams {
+
+	var params []TemplateParams
+	for _, r := range s.Requests() {
+		if r.Path != `/messages/send-template.json` {
+			continue
+		}
+		var p TemplateParams
+		if err := json.Unmarshal(r.Body, &p); err == nil {
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+// SentRawParams decodes the body of every /messages/send-raw.json call received so far
+func (s *Server) SentRawParams() []RawParams {
+
+	var params []RawParams
+	for _, r := range s.Requests() {
+		if r.Path != `/messages/send-raw.json` {
+			continue
+		}
+		var p RawParams
+		if err := json.Unmarshal(r.Body, &p); err == nil {
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+// serveHTTP records the request, applies scripted latency and failures, checks the key, and then dispatches
+// to the endpoint handler
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ERROR_VALIDATION, -2, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Path: r.URL.Path, Body: body})
+	latency := s.latency
+	var failure *scriptedFailure
+	if len(s.failures) > 0 {
+		failure = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if failure != nil {
+		writeJSON(w, failure.httpStatus, failure.body)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ERROR_VALIDATION, -2, `Mandrill API calls must be POSTed`)
+		return
+	}
+
+	var auth struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(body, &auth); err != nil {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `You must specify a key value`)
+		return
+	}
+	if auth.Key != s.Key {
+		writeError(w, http.StatusInternalServerError, ERROR_INVALID_KEY, -1, `Invalid API key`)
+		return
+	}
+
+	s.mux.ServeHTTP(w, requestWithBody(r, body))
+}
+
+// handleSend emulates /messages/send.json
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+
+	var p Params
+	if !decodeBody(w, r, &p) {
+		return
+	}
+
+	if p.Message == nil {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `Validation error: {"message":"Please enter an array"}`)
+		return
+	}
+
+	s.writeRecipientResponses(w, p.Message.To, p.Async, p.SendAt)
+}
+
+// handleSendTemplate emulates /messages/send-template.json
+func (s *Server) handleSendTemplate(w http.ResponseWriter, r *http.Request) {
+
+	var p TemplateParams
+	if !decodeBody(w, r, &p) {
+		return
+	}
+
+	s.mu.Lock()
+	known := s.templates[p.TemplateName]
+	s.mu.Unlock()
+
+	if !known {
+		writeError(w, http.StatusInternalServerError, ERROR_UNKNOWN_TEMPLATE, 5, `No such template "`+p.TemplateName+`"`)
+		return
+	}
+
+	if p.TemplateContent == nil {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `Validation error: {"template_content":"Please enter an array"}`)
+		return
+	}
+
+	if p.Message == nil {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `Validation error: {"message":"Please enter an array"}`)
+		return
+	}
+
+	s.writeRecipientResponses(w, p.Message.To, p.Async, p.SendAt)
+}
+
+// handleSendRaw emulates /messages/send-raw.json. Without a to list, the message is sent to the addresses in
+// its To, Cc and Bcc headers.
+func (s *Server) handleSendRaw(w http.ResponseWriter, r *http.Request) {
+
+	var p RawParams
+	if !decodeBody(w, r, &p) {
+		return
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(p.RawMessage))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `Validation error: {"raw_message":"Please enter a valid MIME message"}`)
+		return
+	}
+
+	var to []Recipient
+	for _, email := range p.To {
+		to = append(to, Recipient{Email: email, Type: `to`})
+	}
+	if len(p.To) == 0 {
+		for _, name := range []string{`To`, `Cc`, `Bcc`} {
+			addrs, _ := msg.Header.AddressList(name)
+			for _, a := range addrs {
+				to = append(to, Recipient{Email: a.Address, Name: a.Name, Type: strings.ToLower(name)})
+			}
+		}
+	}
+
+	s.writeRecipientResponses(w, to, p.Async, p.SendAt)
+}
+
+// writeRecipientResponses answers a send with one response per recipient, honoring scripted statuses
+func (s *Server) writeRecipientResponses(w http.ResponseWriter, to []Recipient, async bool, sendAt string) {
+
+	if len(to) == 0 {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `Validation error: {"message":{"to":"Please enter an array"}}`)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := `sent`
+	if sendAt != `` {
+		status = `scheduled`
+	} else if async {
+		status = `queued`
+	}
+
+	resp := make([]RecipientResponse, len(to), len(to))
+	for i, rcpt := range to {
+
+		s.nextId++
+		r, ok := s.statuses[strings.ToLower(rcpt.Email)]
+		if !ok {
+			r = RecipientResponse{Status: status}
+			if !strings.Contains(rcpt.Email, `@`) {
+				r.Status = `invalid`
+			}
+		}
+		r.Email = rcpt.Email
+		r.Id = fmt.Sprintf("%032x", s.nextId)
+
+		resp[i] = r
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
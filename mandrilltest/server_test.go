@@ -0,0 +1,176 @@
+package mandrilltest_test
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"testing"
+	"time"
+
+	mandrillmail "github.com/jjharr/mandrill-mail"
+	"github.com/jjharr/mandrill-mail/mandrilltest"
+)
+
+func newClient(t *testing.T, server *mandrilltest.Server, key string, opts ...mandrillmail.MandrillOption) mandrillmail.MailerContext {
+
+	sender := &mandrillmail.MailRecipient{Name: `Sender`, Email: `sender@example.com`}
+	opts = append([]mandrillmail.MandrillOption{mandrillmail.WithBaseURL(server.URL)}, opts...)
+
+	m, err := mandrillmail.NewMandrill(key, `example.com`, sender, server.Client(), opts...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return m
+}
+
+func TestServer_BulkMail(t *testing.T) {
+
+	server := mandrilltest.NewServer(`test-key`)
+	defer server.Close()
+	server.SetRecipientStatus(`bounced@example.com`, `rejected`, `hard-bounce`)
+
+	m := newClient(t, server, `test-key`)
+
+	message := &mandrillmail.MailMessage{
+		HTMLTemplate: template.Must(template.New(`server_test`).Parse(`Hello {{.Name}}`)),
+		TemplateVars: map[string]string{`Name`: `World`},
+		Subject:      `Emulator Test`,
+		From:         &mandrillmail.MailRecipient{Email: `from@example.com`},
+		Tags:         []string{`test`},
+	}
+	recipients := []mandrillmail.MailRecipient{
+		{Email: `ok@example.com`, RecipientType: mandrillmail.MAIL_TO},
+		{Email: `bounced@example.com`, RecipientType: mandrillmail.MAIL_TO},
+	}
+
+	resp, err := m.BulkMail(recipients, message, &mandrillmail.SendParams{})
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if resp[0].Status != mandrillmail.MAIL_MESSAGE_SENT || resp[1].Status != mandrillmail.MAIL_MESSAGE_REJECTED ||
+		resp[1].RejectReason != mandrillmail.MAIL_REJECT_HARD_BOUNCE {
+		t.Errorf("Unexpected responses : %+v", resp)
+	}
+
+	sent := server.SentParams()
+	if len(sent) != 1 || sent[0].Message.Html != `Hello World` || len(sent[0].Message.To) != 2 {
+		t.Errorf("Unexpected params recorded : %+v", sent)
+	}
+}
+
+func TestServer_InvalidKey(t *testing.T) {
+
+	server := mandrilltest.NewServer(`test-key`)
+	defer server.Close()
+
+	m := newClient(t, server, `wrong-key`)
+
+	_, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`)
+	if !mandrillmail.IsInvalidKey(err) {
+		t.Errorf("Expected an invalid key error, got %v", err)
+	}
+}
+
+func TestServer_FailNextAndLatency(t *testing.T) {
+
+	server := mandrilltest.NewServer(`test-key`)
+	defer server.Close()
+
+	server.FailNext(1, http.StatusServiceUnavailable, mandrilltest.ErrorResponse{Name: `ServiceUnavailable`, Message: `try again`})
+	m := newClient(t, server, `test-key`, mandrillmail.WithRetryPolicy(&mandrillmail.RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+	}))
+
+	if _, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %s", err.Error())
+	}
+	if len(server.Requests()) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(server.Requests()))
+	}
+
+	server.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := m.SimpleMailContext(ctx, `from@example.com`, `to@example.com`, `Subject`, `Body`); err == nil {
+		t.Error("Expected the deadline to be exceeded")
+	}
+}
+
+func TestServer_SendTemplate(t *testing.T) {
+
+	server := mandrilltest.NewServer(`test-key`)
+	defer server.Close()
+	server.AddTemplate(`welcome`)
+
+	// SendTemplate is Mandrill specific, so use the concrete client rather than the MailerContext interface
+	sender := &mandrillmail.MailRecipient{Name: `Sender`, Email: `sender@example.com`}
+	m, err := mandrillmail.NewMandrill(`test-key`, `example.com`, sender, server.Client(), mandrillmail.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := []mandrillmail.MailRecipient{
+		{Email: `a@example.com`, RecipientType: mandrillmail.MAIL_TO, MergeVars: map[string]string{`FNAME`: `Ann`}},
+	}
+
+	if _, err := m.SendTemplate(recipients, &mandrillmail.TemplateMessage{TemplateName: `welcome`}, nil); err != nil {
+		t.Fatalf("SendTemplate failed with error : %s", err.Error())
+	}
+
+	sent := server.SentTemplateParams()
+	if len(sent) != 1 || sent[0].TemplateName != `welcome` || sent[0].Message.MergeVars[0].Vars[0].Content != `Ann` {
+		t.Errorf("Unexpected template params recorded : %+v", sent)
+	}
+
+	_, err = m.SendTemplate(recipients, &mandrillmail.TemplateMessage{TemplateName: `missing`}, nil)
+	if !mandrillmail.IsUnknownTemplate(err) {
+		t.Errorf("Expected an unknown template error, got %v", err)
+	}
+}
+
+func TestServer_SendRaw(t *testing.T) {
+
+	server := mandrilltest.NewServer(`test-key`)
+	defer server.Close()
+	server.SetRecipientStatus(`bounced@example.com`, `rejected`, `hard-bounce`)
+
+	sender := &mandrillmail.MailRecipient{Name: `Sender`, Email: `sender@example.com`}
+	m, err := mandrillmail.NewMandrill(`test-key`, `example.com`, sender, server.Client(), mandrillmail.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	message := &mandrillmail.MailMessage{
+		TextTemplate: template.Must(template.New(`server_raw`).Parse(`Hello`)),
+		Subject:      `Raw Test`,
+		From:         &mandrillmail.MailRecipient{Email: `from@example.com`},
+	}
+	recipients := []mandrillmail.MailRecipient{
+		{Email: `ok@example.com`, RecipientType: mandrillmail.MAIL_TO},
+		{Email: `bounced@example.com`, RecipientType: mandrillmail.MAIL_CC},
+	}
+
+	eml, err := mandrillmail.ExportEML(recipients, message)
+	if err != nil {
+		t.Fatalf("ExportEML failed with error : %s", err.Error())
+	}
+
+	// without a to list, the recipients come from the message headers
+	resp, err := m.SendRaw(eml, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("SendRaw failed with error : %s", err.Error())
+	}
+
+	if len(resp) != 2 || resp[0].Status != mandrillmail.MAIL_MESSAGE_SENT || resp[1].Status != mandrillmail.MAIL_MESSAGE_REJECTED {
+		t.Errorf("Unexpected responses : %+v", resp)
+	}
+
+	sent := server.SentRawParams()
+	if len(sent) != 1 || sent[0].RawMessage != string(eml) || len(sent[0].To) != 0 {
+		t.Errorf("Unexpected raw params recorded : %+v", sent)
+	}
+}
@@ -0,0 +1,427 @@
+package mandrilltest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTPMessage is a message received by the SMTPServer
+type SMTPMessage struct {
+	// From and To are the envelope sender and the recipients that were accepted
+	From string
+	To   []string
+	Data []byte
+	// TLS reports whether the session used STARTTLS, and Username who it authenticated as
+	TLS      bool
+	Username string
+}
+
+// Parse parses the message data
+func (m SMTPMessage) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(m.Data))
+}
+
+// SMTPServer is a minimal SMTP server on the loopback interface, for testing SMTP mailers. It supports
+// STARTTLS, with a self-signed certificate trusted by ClientTLSConfig, and AUTH PLAIN. Unless scripted
+// otherwise it accepts every message.
+type SMTPServer struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	roots     *x509.CertPool
+	wg        sync.WaitGroup
+
+	mu         sync.Mutex
+	messages   []SMTPMessage
+	rejects    map[string]string
+	username   string
+	password   string
+	noStartTLS bool
+}
+
+// NewSMTPServer starts an SMTP server. Call Close when done.
+func NewSMTPServer() *SMTPServer {
+
+	cert, roots, err := selfSignedCert()
+	if err != nil {
+		panic(`mandrilltest: failed to create certificate: ` + err.Error())
+	}
+
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		panic(`mandrilltest: failed to listen: ` + err.Error())
+	}
+
+	s := &SMTPServer{
+		Addr:      listener.Addr().String(),
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		roots:     roots,
+		rejects:   map[string]string{},
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// ClientTLSConfig returns a client configuration that trusts the server's certificate
+func (s *SMTPServer) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.roots}
+}
+
+// RequireAuth makes the server refuse mail until the client authenticates with username and password
+func (s *SMTPServer) RequireAuth(username, password string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.username = username
+	s.password = password
+}
+
+// DisableSTARTTLS stops the server offering STARTTLS
+func (s *SMTPServer) DisableSTARTTLS() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.noStartTLS = true
+}
+
+// RejectRecipient makes the server refuse email as a recipient with a permanent (550) error
+func (s *SMTPServer) RejectRecipient(email string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rejects[strings.ToLower(email)] = `550 5.1.1 Recipient rejected`
+}
+
+// DeferRecipient makes the server refuse email as a recipient with a temporary (450) error
+func (s *SMTPServer) DeferRecipient(email string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rejects[strings.ToLower(email)] = `450 4.2.1 Mailbox busy, try again later`
+}
+
+// Messages returns every message received so far, oldest first
+func (s *SMTPServer) Messages() []SMTPMessage {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]SMTPMessage(nil), s.messages...)
+}
+
+// Close stops the server and waits for open sessions to end
+func (s *SMTPServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *SMTPServer) serve() {
+
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Minute))
+			s.session(conn)
+		}()
+	}
+}
+
+// smtpSession is the state of one connection
+type smtpSession struct {
+	conn     net.Conn
+	text     *textproto.Conn
+	tls      bool
+	username string
+	from     string
+	to       []string
+	inMail   bool
+}
+
+func (s *SMTPServer) session(conn net.Conn) {
+
+	ss := &smtpSession{conn: conn, text: textproto.NewConn(conn)}
+	ss.text.PrintfLine(`220 mandrilltest ESMTP ready`)
+
+	for {
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, ` `)
+		switch strings.ToUpper(verb) {
+		case `EHLO`:
+			s.ehlo(ss)
+		case `HELO`:
+			ss.text.PrintfLine(`250 mandrilltest`)
+		case `STARTTLS`:
+			if !s.startTLS(ss) {
+				return
+			}
+		case `AUTH`:
+			s.auth(ss, arg)
+		case `MAIL`:
+			s.mail(ss, arg)
+		case `RCPT`:
+			s.rcpt(ss, arg)
+		case `DATA`:
+			if !s.data(ss) {
+				return
+			}
+		case `RSET`:
+			ss.from, ss.to, ss.inMail = ``, nil, false
+			ss.text.PrintfLine(`250 OK`)
+		case `NOOP`:
+			ss.text.PrintfLine(`250 OK`)
+		case `QUIT`:
+			ss.text.PrintfLine(`221 Bye`)
+			return
+		default:
+			ss.text.PrintfLine(`502 Command not implemented`)
+		}
+	}
+}
+
+func (s *SMTPServer) ehlo(ss *smtpSession) {
+
+	s.mu.Lock()
+	startTLS := !s.noStartTLS && !ss.tls
+	auth := s.username != ``
+	s.mu.Unlock()
+
+	lines := []string{`mandrilltest`, `8BITMIME`}
+	if startTLS {
+		lines = append(lines, `STARTTLS`)
+	}
+	if auth {
+		lines = append(lines, `AUTH PLAIN`)
+	}
+
+	for i, l := range lines {
+		sep := `-`
+		if i == len(lines)-1 {
+			sep = ` `
+		}
+		ss.text.PrintfLine(`250%s%s`, sep, l)
+	}
+}
+
+func (s *SMTPServer) startTLS(ss *smtpSession) bool {
+
+	if ss.tls {
+		ss.text.PrintfLine(`503 TLS already active`)
+		return true
+	}
+
+	ss.text.PrintfLine(`220 Ready to start TLS`)
+
+	conn := tls.Server(ss.conn, s.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return false
+	}
+
+	// the session starts over after STARTTLS
+	*ss = smtpSession{conn: conn, text: textproto.NewConn(conn), tls: true}
+
+	return true
+}
+
+func (s *SMTPServer) auth(ss *smtpSession, arg string) {
+
+	mechanism, initial, _ := strings.Cut(arg, ` `)
+	if !strings.EqualFold(mechanism, `PLAIN`) {
+		ss.text.PrintfLine(`504 Unrecognized authentication type`)
+		return
+	}
+
+	if initial == `` {
+		ss.text.PrintfLine(`334 `)
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+		initial = line
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(initial)
+	parts := strings.Split(string(decoded), "\x00")
+	if err != nil || len(parts) != 3 {
+		ss.text.PrintfLine(`501 Malformed AUTH input`)
+		return
+	}
+
+	s.mu.Lock()
+	ok := s.username != `` && parts[1] == s.username && parts[2] == s.password
+	s.mu.Unlock()
+
+	if !ok {
+		ss.text.PrintfLine(`535 Authentication credentials invalid`)
+		return
+	}
+
+	ss.username = parts[1]
+	ss.text.PrintfLine(`235 Authentication successful`)
+}
+
+func (s *SMTPServer) mail(ss *smtpSession, arg string) {
+
+	s.mu.Lock()
+	authRequired := s.username != ``
+	s.mu.Unlock()
+
+	if authRequired && ss.username == `` {
+		ss.text.PrintfLine(`530 Authentication required`)
+		return
+	}
+
+	from, ok := parsePath(arg, `FROM:`)
+	if !ok {
+		ss.text.PrintfLine(`501 Syntax error in MAIL command`)
+		return
+	}
+
+	ss.from, ss.to, ss.inMail = from, nil, true
+	ss.text.PrintfLine(`250 OK`)
+}
+
+func (s *SMTPServer) rcpt(ss *smtpSession, arg string) {
+
+	if !ss.inMail {
+		ss.text.PrintfLine(`503 Need MAIL command`)
+		return
+	}
+
+	to, ok := parsePath(arg, `TO:`)
+	if !ok {
+		ss.text.PrintfLine(`501 Syntax error in RCPT command`)
+		return
+	}
+
+	s.mu.Lock()
+	reply := s.rejects[strings.ToLower(to)]
+	s.mu.Unlock()
+
+	if reply != `` {
+		ss.text.PrintfLine(reply)
+		return
+	}
+
+	ss.to = append(ss.to, to)
+	ss.text.PrintfLine(`250 OK`)
+}
+
+func (s *SMTPServer) data(ss *smtpSession) bool {
+
+	if len(ss.to) == 0 {
+		ss.text.PrintfLine(`503 Need RCPT command`)
+		return true
+	}
+
+	ss.text.PrintfLine(`354 End data with <CR><LF>.<CR><LF>`)
+
+	data, err := ss.text.ReadDotBytes()
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	s.messages = append(s.messages, SMTPMessage{
+		From:     ss.from,
+		To:       ss.to,
+		Data:     data,
+		TLS:      ss.tls,
+		Username: ss.username,
+	})
+	s.mu.Unlock()
+
+	ss.from, ss.to, ss.inMail = ``, nil, false
+	ss.text.PrintfLine(`250 OK: queued`)
+
+	return true
+}
+
+// parsePath extracts the address from a MAIL FROM:<...> or RCPT TO:<...> argument, ignoring any parameters
+func parsePath(arg, prefix string) (string, bool) {
+
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return ``, false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	start := strings.Index(path, `<`)
+	end := strings.Index(path, `>`)
+	if start != 0 || end < start {
+		return ``, false
+	}
+
+	return path[1:end], true
+}
+
+// selfSignedCert creates a certificate for the loopback addresses, and a pool that trusts it
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{`mandrilltest`}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{`localhost`},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, roots, nil
+}
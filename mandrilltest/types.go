@@ -0,0 +1,109 @@
+package mandrilltest
+
+// The types in this file mirror the JSON shapes of the Mandrill API, so that tests can inspect exactly what
+// the client sent. They are deliberately independent of the private types in the mandrillmail package.
+
+// Params is the body of a /messages/send.json request
+type Params struct {
+	Key     string   `json:"key"`
+	Message *Message `json:"message"`
+	Async   bool     `json:"async"`
+	IpPool  string   `json:"ip_pool"`
+	SendAt  string   `json:"send_at"`
+}
+
+// TemplateParams is the body of a /messages/send-template.json request
+type TemplateParams struct {
+	Params
+	TemplateName    string     `json:"template_name"`
+	TemplateContent []MergeVar `json:"template_content"`
+}
+
+// RawParams is the body of a /messages/send-raw.json request
+type RawParams struct {
+	Key        string   `json:"key"`
+	RawMessage string   `json:"raw_message"`
+	FromEmail  string   `json:"from_email"`
+	FromName   string   `json:"from_name"`
+	To         []string `json:"to"`
+	Async      bool     `json:"async"`
+	IpPool     string   `json:"ip_pool"`
+	SendAt     string   `json:"send_at"`
+}
+
+type Message struct {
+	Html                    string              `json:"html"`
+	Text                    string              `json:"text"`
+	Subject                 string              `json:"subject"`
+	FromEmail               string              `json:"from_email"`
+	FromName                string              `json:"from_name"`
+	To                      []Recipient         `json:"to"`
+	Headers                 map[string]string   `json:"headers"`
+	MarkImportant           bool                `json:"important"`
+	TrackOpens              bool                `json:"track_opens"`
+	TrackClicks             bool                `json:"track_clicks"`
+	AutoText                bool                `json:"auto_text"`
+	AutoHtml                bool                `json:"auto_html"`
+	InlineCss               bool                `json:"inline_css"`
+	StripQueryString        bool                `json:"url_strip_qs"`
+	PreserveRecipients      bool                `json:"preserve_recipients"`
+	ViewContentLink         bool                `json:"view_content_link"`
+	BccAddress              string              `json:"bcc_address"`
+	TrackingDomain          string              `json:"tracking_domain"`
+	SigningDomain           string              `json:"signing_domain"`
+	ReturnPathDomain        string              `json:"return_path_domain"`
+	Merge                   bool                `json:"merge"`
+	MergeLanguage           string              `json:"merge_language"`
+	GlobalMergeVars         []MergeVar          `json:"global_merge_vars"`
+	MergeVars               []RecipientMergeVar `json:"merge_vars"`
+	Tags                    []string            `json:"tags"`
+	GoogleAnalyticsDomains  []string            `json:"google_analytics_domains"`
+	GoogleAnalyticsCampaign string              `json:"google_analytics_campaign"`
+	Metadata                map[string]string   `json:"metadata"`
+	RecipientMetadata       []RecipientMetadata `json:"recipient_metadata"`
+	Attachments             []Attachment        `json:"attachments"`
+	Images                  []Attachment        `json:"images"`
+}
+
+type Recipient struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+}
+
+type MergeVar struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+type RecipientMergeVar struct {
+	Rcpt string     `json:"rcpt"`
+	Vars []MergeVar `json:"vars"`
+}
+
+type RecipientMetadata struct {
+	Rcpt   string            `json:"rcpt"`
+	Values map[string]string `json:"values"`
+}
+
+type Attachment struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// RecipientResponse is one element of a successful send response
+type RecipientResponse struct {
+	Email        string `json:"email"`
+	Status       string `json:"status"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	Id           string `json:"_id"`
+}
+
+// ErrorResponse is the body Mandrill returns with a failed call
+type ErrorResponse struct {
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
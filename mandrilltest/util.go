@@ -0,0 +1,54 @@
+package mandrilltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// readBody reads and closes the request body
+func readBody(r *http.Request) ([]byte, error) {
+
+	defer r.Body.Close()
+	return io.ReadAll(r.Body)
+}
+
+// requestWithBody returns a copy of r whose body can be read again
+func requestWithBody(r *http.Request, body []byte) *http.Request {
+
+	r2 := r.Clone(r.Context())
+	r2.Body = io.NopCloser(bytes.NewReader(body))
+
+	return r2
+}
+
+// decodeBody decodes the JSON request body into v, answering with a ValidationError if it can't
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, ERROR_VALIDATION, -2, `Validation error: `+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// writeError writes a Mandrill-style error body
+func writeError(w http.ResponseWriter, httpStatus int, name string, code int, message string) {
+
+	writeJSON(w, httpStatus, ErrorResponse{
+		Status:  `error`,
+		Code:    code,
+		Name:    name,
+		Message: message,
+	})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, httpStatus int, v interface{}) {
+
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,322 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// @see https://mailchimp.com/developer/transactional/api/messages/get-message-info/
+
+// Mandrill allows at most this many results per search
+const MAX_SEARCH_LIMIT = 1000
+
+// MessageInfo is what Mandrill knows about a sent message, from its id or a search. Mandrill keeps this for a
+// limited time after sending.
+type MessageInfo struct {
+	Id       string            `json:"_id"`
+	Ts       int64             `json:"ts"`
+	Sender   string            `json:"sender"`
+	Template string            `json:"template"`
+	Subject  string            `json:"subject"`
+	Email    string            `json:"email"`
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+	// State is sent, bounced, rejected, soft-bounced, deferred or spam
+	State        string               `json:"state"`
+	Opens        int                  `json:"opens"`
+	OpensDetail  []MessageOpenDetail  `json:"opens_detail"`
+	Clicks       int                  `json:"clicks"`
+	ClicksDetail []MessageClickDetail `json:"clicks_detail"`
+	SmtpEvents   []WebhookSmtpEvent   `json:"smtp_events"`
+}
+
+// Time returns when the message was sent
+func (mi *MessageInfo) Time() time.Time {
+	return time.Unix(mi.Ts, 0)
+}
+
+type MessageOpenDetail struct {
+	Ts        int64  `json:"ts"`
+	IP        string `json:"ip"`
+	Location  string `json:"location"`
+	UserAgent string `json:"ua"`
+}
+
+type MessageClickDetail struct {
+	Ts        int64  `json:"ts"`
+	URL       string `json:"url"`
+	IP        string `json:"ip"`
+	Location  string `json:"location"`
+	UserAgent string `json:"ua"`
+}
+
+// MessageSearch filters a message search. All fields are optional.
+type MessageSearch struct {
+	// Query uses Mandrill's search syntax, e.g. `email:user@example.com` or `u_user_id:42` for metadata
+	Query string
+	// DateFrom and DateTo bound the search by day, inclusively
+	DateFrom time.Time
+	DateTo   time.Time
+	// Tags and Senders each match messages having any of the listed values
+	Tags    []string
+	Senders []string
+	// Limit caps the number of results. Mandrill defaults to 100 and allows up to MAX_SEARCH_LIMIT.
+	Limit int
+}
+
+func (ms *MessageSearch) validate() error {
+
+	if ms.Limit < 0 || ms.Limit > MAX_SEARCH_LIMIT {
+		return errors.New("Limit must be between 0 and 1000")
+	}
+
+	if !ms.DateFrom.IsZero() && !ms.DateTo.IsZero() && ms.DateTo.Before(ms.DateFrom) {
+		return errors.New("DateTo must not be before DateFrom")
+	}
+
+	return nil
+}
+
+type mandrillSearchParams struct {
+	Key      string   `json:"key"`
+	Query    string   `json:"query,omitempty"`
+	DateFrom string   `json:"date_from,omitempty"`
+	DateTo   string   `json:"date_to,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Senders  []string `json:"senders,omitempty"`
+	Limit    int      `json:"limit,omitempty"`
+}
+
+// MessageTimeSeries is the activity of the messages matching a search during one hour
+type MessageTimeSeries struct {
+	Time         time.Time
+	Sent         int
+	HardBounces  int
+	SoftBounces  int
+	Rejects      int
+	Complaints   int
+	Unsubs       int
+	Opens        int
+	UniqueOpens  int
+	Clicks       int
+	UniqueClicks int
+}
+
+type mandrillTimeSeries struct {
+	Time         string `json:"time"`
+	Sent         int    `json:"sent"`
+	HardBounces  int    `json:"hard_bounces"`
+	SoftBounces  int    `json:"soft_bounces"`
+	Rejects      int    `json:"rejects"`
+	Complaints   int    `json:"complaints"`
+	Unsubs       int    `json:"unsubs"`
+	Opens        int    `json:"opens"`
+	UniqueOpens  int    `json:"unique_opens"`
+	Clicks       int    `json:"clicks"`
+	UniqueClicks int    `json:"unique_clicks"`
+}
+
+// MessageContent is the full content of a recently sent message
+type MessageContent struct {
+	Id          string
+	Ts          int64
+	FromEmail   string
+	FromName    string
+	Subject     string
+	To          MailRecipient
+	Tags        []string
+	Headers     textproto.MIMEHeader
+	Text        string
+	Html        string
+	Attachments []EmailAttachment
+}
+
+type mandrillMessageContent struct {
+	Id        string `json:"_id"`
+	Ts        int64  `json:"ts"`
+	FromEmail string `json:"from_email"`
+	FromName  string `json:"from_name"`
+	Subject   string `json:"subject"`
+	To        struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	} `json:"to"`
+	Tags        []string                   `json:"tags"`
+	Headers     map[string]json.RawMessage `json:"headers"`
+	Text        string                     `json:"text"`
+	Html        string                     `json:"html"`
+	Attachments []mandrillAttachment       `json:"attachments"`
+}
+
+type mandrillIdParams struct {
+	Key string `json:"key"`
+	Id  string `json:"id"`
+}
+
+// MessageInfo looks up a sent message by the Id in its MailRecipientResponse
+func (m *mandrill) MessageInfo(id string) (*MessageInfo, error) {
+	return m.MessageInfoContext(context.Background(), id)
+}
+
+// MessageInfoContext is MessageInfo with a context that bounds the API call
+func (m *mandrill) MessageInfoContext(ctx context.Context, id string) (*MessageInfo, error) {
+
+	if strings.TrimSpace(id) == `` {
+		return nil, errors.New("MessageInfo: Must specify id;")
+	}
+
+	info := new(MessageInfo)
+	if err := m.call(ctx, MANDRILL_INFO_PATH, &mandrillIdParams{Key: m.key, Id: id}, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// SearchMessages finds recently sent messages matching search, newest first
+func (m *mandrill) SearchMessages(search *MessageSearch) ([]MessageInfo, error) {
+	return m.SearchMessagesContext(context.Background(), search)
+}
+
+// SearchMessagesContext is SearchMessages with a context that bounds the API call
+func (m *mandrill) SearchMessagesContext(ctx context.Context, search *MessageSearch) ([]MessageInfo, error) {
+
+	params, err := m.buildSearchParams(search)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MessageInfo
+	if err := m.call(ctx, MANDRILL_SEARCH_PATH, params, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// SearchMessagesTimeSeries returns hourly activity for the messages matching search. Limit is ignored.
+func (m *mandrill) SearchMessagesTimeSeries(search *MessageSearch) ([]MessageTimeSeries, error) {
+	return m.SearchMessagesTimeSeriesContext(context.Background(), search)
+}
+
+// SearchMessagesTimeSeriesContext is SearchMessagesTimeSeries with a context that bounds the API call
+func (m *mandrill) SearchMessagesTimeSeriesContext(ctx context.Context, search *MessageSearch) ([]MessageTimeSeries, error) {
+
+	params, err := m.buildSearchParams(search)
+	if err != nil {
+		return nil, err
+	}
+	params.Limit = 0
+
+	var results []mandrillTimeSeries
+	if err := m.call(ctx, MANDRILL_SEARCH_TIME_SERIES_PATH, params, &results); err != nil {
+		return nil, err
+	}
+
+	series := make([]MessageTimeSeries, len(results), len(results))
+	for i, v := range results {
+		t, err := time.Parse(mandrillDateTimeFormat, v.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		series[i] = MessageTimeSeries{
+			Time:         t,
+			Sent:         v.Sent,
+			HardBounces:  v.HardBounces,
+			SoftBounces:  v.SoftBounces,
+			Rejects:      v.Rejects,
+			Complaints:   v.Complaints,
+			Unsubs:       v.Unsubs,
+			Opens:        v.Opens,
+			UniqueOpens:  v.UniqueOpens,
+			Clicks:       v.Clicks,
+			UniqueClicks: v.UniqueClicks,
+		}
+	}
+
+	return series, nil
+}
+
+// MessageContent returns the full content of a sent message. Mandrill only keeps content for a few days.
+func (m *mandrill) MessageContent(id string) (*MessageContent, error) {
+	return m.MessageContentContext(context.Background(), id)
+}
+
+// MessageContentContext is MessageContent with a context that bounds the API call
+func (m *mandrill) MessageContentContext(ctx context.Context, id string) (*MessageContent, error) {
+
+	if strings.TrimSpace(id) == `` {
+		return nil, errors.New("MessageContent: Must specify id;")
+	}
+
+	var content mandrillMessageContent
+	if err := m.call(ctx, MANDRILL_CONTENT_PATH, &mandrillIdParams{Key: m.key, Id: id}, &content); err != nil {
+		return nil, err
+	}
+
+	headers, err := parseMandrillHeaders(content.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []EmailAttachment
+	for _, a := range content.Attachments {
+		attachments = append(attachments, EmailAttachment{
+			Name:          a.Name,
+			MimeType:      a.MimeType,
+			Base64Content: a.Base64Content,
+		})
+	}
+
+	return &MessageContent{
+		Id:        content.Id,
+		Ts:        content.Ts,
+		FromEmail: content.FromEmail,
+		FromName:  content.FromName,
+		Subject:   content.Subject,
+		To: MailRecipient{
+			Email:         content.To.Email,
+			Name:          content.To.Name,
+			RecipientType: MAIL_TO,
+		},
+		Tags:        content.Tags,
+		Headers:     headers,
+		Text:        content.Text,
+		Html:        content.Html,
+		Attachments: attachments,
+	}, nil
+}
+
+// buildSearchParams converts a MessageSearch to Mandrill's format. Dates are sent as UTC days.
+func (m *mandrill) buildSearchParams(search *MessageSearch) (*mandrillSearchParams, error) {
+
+	if search == nil {
+		search = new(MessageSearch)
+	}
+
+	if err := search.validate(); err != nil {
+		return nil, err
+	}
+
+	params := &mandrillSearchParams{
+		Key:     m.key,
+		Query:   search.Query,
+		Tags:    search.Tags,
+		Senders: search.Senders,
+		Limit:   search.Limit,
+	}
+
+	if !search.DateFrom.IsZero() {
+		params.DateFrom = search.DateFrom.UTC().Format(mandrillDateFormat)
+	}
+	if !search.DateTo.IsZero() {
+		params.DateTo = search.DateTo.UTC().Format(mandrillDateFormat)
+	}
+
+	return params, nil
+}
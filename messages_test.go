@@ -0,0 +1,132 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMandrill_MessageInfo(t *testing.T) {
+
+	var params mandrillIdParams
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != MANDRILL_INFO_PATH {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		w.Write([]byte(`{"_id":"abc123","ts":1700000000,"email":"to@example.com","state":"sent","tags":["welcome"],
+			"metadata":{"user":"42"},"opens":1,"opens_detail":[{"ts":1700000100,"ip":"127.0.0.1","ua":"Chrome"}],
+			"clicks":0,"smtp_events":[{"ts":1700000001,"type":"sent","diag":"250 OK"}]}`))
+	})
+
+	info, err := m.MessageInfo(`abc123`)
+	if err != nil {
+		t.Fatalf("MessageInfo failed with error : %s", err.Error())
+	}
+
+	if params.Id != `abc123` || params.Key != `local-test-key` {
+		t.Errorf("Unexpected params %+v", params)
+	}
+
+	if info.Email != `to@example.com` || info.State != `sent` || info.Metadata[`user`] != `42` || info.Opens != 1 {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if len(info.OpensDetail) != 1 || len(info.SmtpEvents) != 1 || info.SmtpEvents[0].Diag != `250 OK` {
+		t.Errorf("Unexpected details %+v %+v", info.OpensDetail, info.SmtpEvents)
+	}
+	if info.Time().Unix() != 1700000000 {
+		t.Errorf("Unexpected time %s", info.Time())
+	}
+
+	if _, err := m.MessageInfo(` `); err == nil {
+		t.Errorf("Expected an error for a missing id")
+	}
+}
+
+func TestMandrill_SearchMessages(t *testing.T) {
+
+	var (
+		paths  []string
+		params []map[string]interface{}
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&p)
+		paths = append(paths, r.URL.Path)
+		params = append(params, p)
+
+		if r.URL.Path == MANDRILL_SEARCH_TIME_SERIES_PATH {
+			w.Write([]byte(`[{"time":"2024-03-01 15:00:00","sent":10,"hard_bounces":1,"opens":4,"unique_opens":3}]`))
+			return
+		}
+		w.Write([]byte(`[{"_id":"abc123","email":"to@example.com","state":"bounced"}]`))
+	})
+
+	search := &MessageSearch{
+		Query:    `email:to@example.com`,
+		DateFrom: time.Date(2024, 3, 1, 23, 0, 0, 0, time.FixedZone(`UTC-2`, -2*60*60)),
+		DateTo:   time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		Tags:     []string{`welcome`},
+		Limit:    10,
+	}
+
+	results, err := m.SearchMessages(search)
+	if err != nil {
+		t.Fatalf("SearchMessages failed with error : %s", err.Error())
+	}
+	if len(results) != 1 || results[0].State != `bounced` {
+		t.Errorf("Unexpected results %+v", results)
+	}
+
+	p := params[0]
+	if paths[0] != MANDRILL_SEARCH_PATH || p[`query`] != `email:to@example.com` || p[`limit`] != float64(10) {
+		t.Errorf("Unexpected search %s %v", paths[0], p)
+	}
+	if p[`date_from`] != `2024-03-02` || p[`date_to`] != `2024-03-05` {
+		t.Errorf("Expected UTC dates, got %v and %v", p[`date_from`], p[`date_to`])
+	}
+	if _, ok := p[`senders`]; ok {
+		t.Errorf("Expected unset filters to be omitted, got %v", p)
+	}
+
+	series, err := m.SearchMessagesTimeSeries(search)
+	if err != nil {
+		t.Fatalf("SearchMessagesTimeSeries failed with error : %s", err.Error())
+	}
+	if _, ok := params[1][`limit`]; ok || paths[1] != MANDRILL_SEARCH_TIME_SERIES_PATH {
+		t.Errorf("Unexpected time series search %s %v", paths[1], params[1])
+	}
+	if len(series) != 1 || series[0].Sent != 10 || series[0].UniqueOpens != 3 ||
+		!series[0].Time.Equal(time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected series %+v", series)
+	}
+
+	if _, err := m.SearchMessages(&MessageSearch{Limit: MAX_SEARCH_LIMIT + 1}); err == nil {
+		t.Errorf("Expected an error for a limit over the maximum")
+	}
+	if _, err := m.SearchMessages(&MessageSearch{DateFrom: search.DateTo, DateTo: search.DateFrom}); err == nil {
+		t.Errorf("Expected an error for an inverted date range")
+	}
+}
+
+func TestMandrill_MessageContent(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"_id":"abc123","ts":1700000000,"from_email":"from@example.com","subject":"Hello",
+			"to":{"email":"to@example.com","name":"To"},"headers":{"Reply-To":"reply@example.com"},
+			"text":"Hello","html":"<p>Hello</p>","attachments":[{"name":"a.txt","type":"text/plain","content":"aGk="}]}`))
+	})
+
+	content, err := m.MessageContent(`abc123`)
+	if err != nil {
+		t.Fatalf("MessageContent failed with error : %s", err.Error())
+	}
+
+	if content.To.Email != `to@example.com` || content.Html != `<p>Hello</p>` || content.Headers.Get(`Reply-To`) != `reply@example.com` {
+		t.Errorf("Unexpected content %+v", content)
+	}
+	if len(content.Attachments) != 1 || content.Attachments[0].MimeType != `text/plain` || content.Attachments[0].Base64Content != `aGk=` {
+		t.Errorf("Unexpected attachments %+v", content.Attachments)
+	}
+}
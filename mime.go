@@ -0,0 +1,384 @@
+package mandrillmail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// @see https://datatracker.ietf.org/doc/html/rfc5322 and https://datatracker.ietf.org/doc/html/rfc2045
+
+// mimeMessage is a rendered message, ready to be written in RFC 5322 / MIME format for providers that take
+// whole messages rather than JSON
+type mimeMessage struct {
+	From    *MailRecipient
+	To      []MailRecipient
+	Cc      []MailRecipient
+	ReplyTo string
+	Subject string
+	Html    string
+	Text    string
+	// Attachments are attached as files, and Images inline with their Name as the Content-ID
+	Attachments []EmailAttachment
+	Images      []EmailAttachment
+	Important   bool
+	MessageId   string
+	Date        time.Time
+	// Headers are extra headers, written after the standard ones
+	Headers map[string]string
+}
+
+// newMIMEMessage renders message for recipients with vars, in the same way as the Mandrill client. Bcc
+// recipients are left out of the headers. The Message-ID is generated within domain.
+func newMIMEMessage(domain string, recipients []MailRecipient, message *MailMessage, vars map[string]string) (*mimeMessage, error) {
+
+	html, text, err := renderMessageContent(message, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newMessageId(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	mm := &mimeMessage{
+		From:        message.From,
+		ReplyTo:     message.ReplyTo,
+		Subject:     message.Subject,
+		Html:        html,
+		Text:        text,
+		Attachments: message.Attachments,
+		Images:      message.Images,
+		Important:   message.MarkImportant,
+		MessageId:   id,
+		Date:        time.Now(),
+	}
+	mm.setRecipients(recipients)
+
+	return mm, nil
+}
+
+// ExportEML renders message for recipients as a complete RFC 5322 / MIME message, as it would be sent. The
+// result can be archived, opened in a desktop mail client as a .eml file, or sent with SendRaw. Bcc
+// recipients are left out of the headers. With MERGE_MODE_LOCAL there must be exactly one recipient, whose
+// merge vars are used.
+func ExportEML(recipients []MailRecipient, message *MailMessage) ([]byte, error) {
+
+	buf := new(bytes.Buffer)
+	if err := WriteEML(buf, recipients, message); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteEML is ExportEML writing to w
+func WriteEML(w io.Writer, recipients []MailRecipient, message *MailMessage) error {
+
+	if message == nil {
+		return errors.New("ExportEML: Must specify message;")
+	}
+
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := message.validate(); err != nil {
+		return err
+	}
+
+	vars := message.TemplateVars
+	if message.MergeMode == MERGE_MODE_LOCAL {
+		if len(recipients) != 1 {
+			return errors.New("ExportEML: MERGE_MODE_LOCAL messages must be exported one recipient at a time;")
+		}
+		vars = recipientTemplateVars(message, &recipients[0])
+	}
+
+	// the Message-ID is generated in the sender's domain
+	domain := `localhost`
+	if i := strings.LastIndex(message.From.Email, `@`); i >= 0 && i < len(message.From.Email)-1 {
+		domain = message.From.Email[i+1:]
+	}
+
+	mm, err := newMIMEMessage(domain, recipients, message, vars)
+	if err != nil {
+		return err
+	}
+
+	_, err = mm.WriteTo(w)
+	return err
+}
+
+// setRecipients sets the To and Cc headers from recipients. Bcc recipients only appear in the envelope.
+func (mm *mimeMessage) setRecipients(recipients []MailRecipient) {
+
+	for _, r := range recipients {
+		switch r.RecipientType {
+		case MAIL_TO:
+			mm.To = append(mm.To, r)
+		case MAIL_CC:
+			mm.Cc = append(mm.Cc, r)
+		}
+	}
+}
+
+// newMessageId generates a globally unique Message-ID within domain
+func newMessageId(domain string) (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ``, err
+	}
+
+	return hex.EncodeToString(b) + `@` + domain, nil
+}
+
+// mimePart is a node of a MIME body: either a leaf with content, or a multipart container of other parts
+type mimePart struct {
+	header   textproto.MIMEHeader
+	body     []byte
+	subtype  string
+	children []*mimePart
+}
+
+// WriteTo writes the complete message, headers and body, with CRLF line endings
+func (mm *mimeMessage) WriteTo(w io.Writer) (int64, error) {
+
+	if mm.From == nil || mm.From.Email == `` {
+		return 0, errors.New("MIME: Must specify sender;")
+	}
+
+	body, err := mm.body()
+	if err != nil {
+		return 0, err
+	}
+
+	header, content, err := body.render()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := new(bytes.Buffer)
+
+	writeHeader(buf, `From`, formatAddress(*mm.From))
+	if len(mm.To) == 0 && len(mm.Cc) == 0 {
+		writeHeader(buf, `To`, `undisclosed-recipients:;`)
+	}
+	if len(mm.To) > 0 {
+		writeHeader(buf, `To`, formatAddressList(mm.To))
+	}
+	if len(mm.Cc) > 0 {
+		writeHeader(buf, `Cc`, formatAddressList(mm.Cc))
+	}
+	if mm.ReplyTo != `` {
+		writeHeader(buf, `Reply-To`, mm.ReplyTo)
+	}
+	writeHeader(buf, `Subject`, mime.QEncoding.Encode(`utf-8`, mm.Subject))
+	writeHeader(buf, `Date`, mm.Date.Format(time.RFC1123Z))
+	if mm.MessageId != `` {
+		writeHeader(buf, `Message-ID`, `<`+mm.MessageId+`>`)
+	}
+	writeHeader(buf, `MIME-Version`, `1.0`)
+	if mm.Important {
+		writeHeader(buf, `Importance`, `high`)
+		writeHeader(buf, `X-Priority`, `1`)
+	}
+	for _, name := range sortedKeys(mm.Headers) {
+		writeHeader(buf, name, mime.QEncoding.Encode(`utf-8`, mm.Headers[name]))
+	}
+	writeMIMEHeader(buf, header)
+	buf.WriteString("\r\n")
+	buf.Write(content)
+
+	return buf.WriteTo(w)
+}
+
+// Bytes returns the complete message
+func (mm *mimeMessage) Bytes() ([]byte, error) {
+
+	buf := new(bytes.Buffer)
+	if _, err := mm.WriteTo(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// body arranges the content as multipart/mixed for attachments, containing multipart/related for inline
+// images, containing multipart/alternative for the text and HTML versions. Levels that aren't needed are
+// left out.
+func (mm *mimeMessage) body() (*mimePart, error) {
+
+	var alternatives []*mimePart
+	if mm.Text != `` || mm.Html == `` {
+		alternatives = append(alternatives, textPart(`text/plain`, mm.Text))
+	}
+	if mm.Html != `` {
+		alternatives = append(alternatives, textPart(`text/html`, mm.Html))
+	}
+
+	body := alternatives[0]
+	if len(alternatives) > 1 {
+		body = &mimePart{subtype: `alternative`, children: alternatives}
+	}
+
+	if len(mm.Images) > 0 {
+		related := &mimePart{subtype: `related`, children: []*mimePart{body}}
+		for _, img := range mm.Images {
+			p, err := attachmentPart(img, `inline`)
+			if err != nil {
+				return nil, err
+			}
+			p.header.Set(`Content-ID`, `<`+img.Name+`>`)
+			related.children = append(related.children, p)
+		}
+		body = related
+	}
+
+	if len(mm.Attachments) > 0 {
+		mixed := &mimePart{subtype: `mixed`, children: []*mimePart{body}}
+		for _, a := range mm.Attachments {
+			p, err := attachmentPart(a, `attachment`)
+			if err != nil {
+				return nil, err
+			}
+			mixed.children = append(mixed.children, p)
+		}
+		body = mixed
+	}
+
+	return body, nil
+}
+
+// render returns the headers and encoded content of the part
+func (p *mimePart) render() (textproto.MIMEHeader, []byte, error) {
+
+	if p.subtype == `` {
+		return p.header, p.body, nil
+	}
+
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	for _, child := range p.children {
+
+		header, content, err := child.render()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set(`Content-Type`, `multipart/`+p.subtype+`; boundary=`+mw.Boundary())
+
+	return header, buf.Bytes(), nil
+}
+
+// textPart encodes text as quoted-printable UTF-8
+func textPart(mimeType, text string) *mimePart {
+
+	buf := new(bytes.Buffer)
+	qp := quotedprintable.NewWriter(buf)
+	qp.Write([]byte(text))
+	qp.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set(`Content-Type`, mimeType+`; charset=utf-8`)
+	header.Set(`Content-Transfer-Encoding`, `quoted-printable`)
+
+	return &mimePart{header: header, body: buf.Bytes()}
+}
+
+// attachmentPart re-encodes an attachment's content as base64 in lines of 76 characters
+func attachmentPart(a EmailAttachment, disposition string) (*mimePart, error) {
+
+	content, err := base64.StdEncoding.DecodeString(a.Base64Content)
+	if err != nil {
+		return nil, fmt.Errorf("MIME: invalid base64 content for %s: %s", a.Name, err.Error())
+	}
+
+	mimeType := a.MimeType
+	if mimeType == `` {
+		mimeType = `application/octet-stream`
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set(`Content-Type`, mime.FormatMediaType(mimeType, map[string]string{`name`: a.Name}))
+	header.Set(`Content-Disposition`, mime.FormatMediaType(disposition, map[string]string{`filename`: a.Name}))
+	header.Set(`Content-Transfer-Encoding`, `base64`)
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+	buf := new(bytes.Buffer)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+
+	return &mimePart{header: header, body: buf.Bytes()}, nil
+}
+
+// formatAddress formats a recipient as an RFC 5322 address, encoding the name if necessary
+func formatAddress(r MailRecipient) string {
+	return (&mail.Address{Name: r.Name, Address: r.Email}).String()
+}
+
+// formatAddressList formats recipients as an address list, folded one address per line
+func formatAddressList(recipients []MailRecipient) string {
+
+	addrs := make([]string, len(recipients), len(recipients))
+	for i, r := range recipients {
+		addrs[i] = formatAddress(r)
+	}
+
+	return strings.Join(addrs, ",\r\n ")
+}
+
+// writeHeader writes one header field, dropping any line breaks in the value that aren't folds
+func writeHeader(buf *bytes.Buffer, name, value string) {
+
+	value = strings.NewReplacer("\r\n ", "\r\n ", "\r", ``, "\n", ``).Replace(value)
+	buf.WriteString(name + `: ` + value + "\r\n")
+}
+
+// writeMIMEHeader writes the header fields of a part in sorted order
+func writeMIMEHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range header[name] {
+			writeHeader(buf, name, v)
+		}
+	}
+}
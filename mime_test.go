@@ -0,0 +1,104 @@
+package mandrillmail
+
+import (
+	"bytes"
+	"html/template"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestExportEML(t *testing.T) {
+
+	message := &MailMessage{
+		HTMLTemplate: template.Must(template.New(`eml_html`).Parse(`<p>Booking {{.booking}}</p>`)),
+		TextTemplate: template.Must(template.New(`eml_text`).Parse(`Booking {{.booking}}`)),
+		TemplateVars: map[string]string{`booking`: `B-42`},
+		Subject:      `Your booking`,
+		From:         &MailRecipient{Name: `Bookings`, Email: `bookings@example.com`},
+		Attachments:  []EmailAttachment{{Name: `booking.pdf`, MimeType: `application/pdf`, Base64Content: `JVBERi0xLjQ=`}},
+	}
+	recipients := []MailRecipient{
+		{Email: `to@example.com`, RecipientType: MAIL_TO},
+		{Email: `archive@example.com`, RecipientType: MAIL_BCC},
+	}
+
+	eml, err := ExportEML(recipients, message)
+	if err != nil {
+		t.Fatalf("ExportEML failed with error : %s", err.Error())
+	}
+
+	if !bytes.Contains(eml, []byte("\r\n\r\n")) || bytes.Contains(bytes.ReplaceAll(eml, []byte("\r\n"), nil), []byte("\n")) {
+		t.Errorf("Expected CRLF line endings throughout")
+	}
+	if bytes.Contains(eml, []byte(`archive@example.com`)) {
+		t.Errorf("Expected Bcc recipients to be left out")
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(eml))
+	if err != nil {
+		t.Fatalf("Failed to parse exported message : %s", err.Error())
+	}
+
+	h := parsed.Header
+	if h.Get(`From`) != `"Bookings" <bookings@example.com>` || h.Get(`To`) != `<to@example.com>` || h.Get(`Mime-Version`) != `1.0` {
+		t.Errorf("Unexpected headers %v", h)
+	}
+	if !strings.HasSuffix(h.Get(`Message-Id`), `@example.com>`) {
+		t.Errorf("Expected a Message-ID in the sender's domain, got %q", h.Get(`Message-Id`))
+	}
+	if _, err := h.Date(); err != nil {
+		t.Errorf("Expected a valid Date header, got %q", h.Get(`Date`))
+	}
+
+	mixed := readMultipart(t, h.Get(`Content-Type`), parsed.Body, `multipart/mixed`, 2)
+	alternative := readMultipart(t, mixed[0].header.Get(`Content-Type`), strings.NewReader(mixed[0].body), `multipart/alternative`, 2)
+
+	// the text and html templates are each executed, as for Mandrill
+	if alternative[0].body != `Booking B-42` || alternative[1].body != `<p>Booking B-42</p>` {
+		t.Errorf("Unexpected content %q and %q", alternative[0].body, alternative[1].body)
+	}
+	if mixed[1].body != `%PDF-1.4` || mixed[1].header.Get(`Content-Type`) != `application/pdf; name=booking.pdf` {
+		t.Errorf("Unexpected attachment %q %v", mixed[1].body, mixed[1].header)
+	}
+}
+
+func TestExportEML_Validation(t *testing.T) {
+
+	message := &MailMessage{
+		TextTemplate: template.Must(template.New(`eml_local`).Parse(`Hi {{.name}}`)),
+		Subject:      "Multi\r\nBcc: injected@example.com",
+		From:         &MailRecipient{Email: `from@example.com`},
+		MergeMode:    MERGE_MODE_LOCAL,
+		Attachments:  []EmailAttachment{{Name: `bad.bin`, Base64Content: `not base64!`}},
+	}
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`name`: `A`}},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+	}
+
+	if _, err := ExportEML(recipients, message); err == nil {
+		t.Errorf("Expected an error for several recipients in local merge mode")
+	}
+
+	if _, err := ExportEML(recipients[:1], message); err == nil {
+		t.Errorf("Expected an error for invalid attachment content")
+	}
+
+	message.Attachments = nil
+	eml, err := ExportEML(recipients[:1], message)
+	if err != nil {
+		t.Fatalf("ExportEML failed with error : %s", err.Error())
+	}
+
+	parsed, _ := mail.ReadMessage(bytes.NewReader(eml))
+	if parsed.Header.Get(`Bcc`) != `` {
+		t.Errorf("Expected line breaks in the subject not to inject headers")
+	}
+	if body := new(bytes.Buffer); true {
+		body.ReadFrom(parsed.Body)
+		if body.String() != `Hi A` {
+			t.Errorf("Expected the recipient's merge vars, got %q", body.String())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package mandrillmail
+
+import (
+	"errors"
+	"strings"
+)
+
+// MandrillOption configures an optional setting on the mandrill client. Options are applied in order
+// by NewMandrill, and the first one to return an error aborts construction.
+type MandrillOption func(m *mandrill) error
+
+// WithBaseURL sends all API calls to baseURL instead of MANDRILL_BASE_URL. This is mostly useful for
+// pointing the client at a local stand-in server (e.g. an httptest.Server) in tests.
+func WithBaseURL(baseURL string) MandrillOption {
+	return func(m *mandrill) error {
+		baseURL = strings.TrimRight(strings.TrimSpace(baseURL), `/`)
+		if baseURL == `` {
+			return errors.New("WithBaseURL: base URL must not be empty")
+		}
+		m.baseURL = baseURL
+		return nil
+	}
+}
+
+// WithEndpointPath overrides the path of a single API endpoint. The endpoint is identified by its default
+// path, so WithEndpointPath(MANDRILL_MESSAGE_PATH, `/send`) routes message sends to baseURL + `/send`.
+func WithEndpointPath(endpoint, path string) MandrillOption {
+	return func(m *mandrill) error {
+		if endpoint == `` || path == `` {
+			return errors.New("WithEndpointPath: endpoint and path must not be empty")
+		}
+		if !strings.HasPrefix(path, `/`) {
+			path = `/` + path
+		}
+		m.paths[endpoint] = path
+		return nil
+	}
+}
+
+// endpointURL resolves the full URL of an API endpoint, honoring any WithBaseURL and WithEndpointPath
+// settings. Every API call should build its URL through here.
+func (m *mandrill) endpointURL(endpoint string) string {
+
+	path := endpoint
+	if p, ok := m.paths[endpoint]; ok {
+		path = p
+	}
+
+	return m.baseURL + path
+}
@@ -0,0 +1,493 @@
+package mandrillmail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueuedMail is a mail job as persisted by a QueueStore. The message content is rendered when the job is
+// enqueued, so a job is a self-contained snapshot that can be delivered after a restart.
+type QueuedMail struct {
+	Id         string
+	Recipients []MailRecipient
+	Message    QueuedMessage
+	Params     SendParams
+	Created    time.Time
+	// Attempts is the number of failed delivery attempts so far
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	// Dead is set once the job has been moved to the dead letters, where it stays until requeued or deleted
+	Dead bool
+}
+
+// QueuedMessage is the serializable form of a MailMessage, holding rendered content instead of templates
+type QueuedMessage struct {
+	Html            string
+	Text            string
+	AutoText        bool
+	Subject         string
+	From            *MailRecipient
+	ReplyTo         string
+	Attachments     []EmailAttachment
+	Images          []EmailAttachment
+	MarkImportant   bool
+	Tags            []string
+	Metadata        map[string]string
+	GlobalMergeVars map[string]string
+	MergeMode       MergeMode
+}
+
+// QueueStore persists queued mail. Implementations must be safe for concurrent use, and should return copies
+// so that callers can't modify stored jobs in place.
+type QueueStore interface {
+	// Save inserts or replaces the job with the same Id
+	Save(job *QueuedMail) error
+	// Delete removes a job. Deleting a job that doesn't exist is not an error.
+	Delete(id string) error
+	// List returns all jobs, including dead letters
+	List() ([]*QueuedMail, error)
+}
+
+// QueueConfig configures a Queue
+type QueueConfig struct {
+	// Retry sets the attempts before a job is dead-lettered, and the backoff between them. OnAttempt is not
+	// used. Defaults to 5 attempts backing off from 30s to 30m.
+	Retry *RetryPolicy
+	// OnDeadLetter, if set, is called when a job is moved to the dead letters
+	OnDeadLetter func(job *QueuedMail)
+	// Logger receives queue activity. Defaults to discarding it.
+	Logger Logger
+}
+
+// Queue is a durable outbound mail queue. Enqueue persists a job before returning, and the job is then
+// delivered through the Mailer by ProcessReady or Run, with retries and dead-lettering. Delivery is
+// at-least-once: a crash between a successful send and the job's removal causes it to be sent again.
+type Queue struct {
+	mailer Mailer
+	store  QueueStore
+	config QueueConfig
+	now    func() time.Time
+
+	// mu serializes processing, so a job is never delivered twice concurrently by the same Queue
+	mu sync.Mutex
+}
+
+// NewQueue creates a Queue that delivers through mailer and persists jobs in store
+func NewQueue(mailer Mailer, store QueueStore, config QueueConfig) (*Queue, error) {
+
+	if mailer == nil {
+		return nil, errors.New("mailer is required")
+	}
+
+	if store == nil {
+		return nil, errors.New("store is required")
+	}
+
+	if config.Retry == nil {
+		config.Retry = &RetryPolicy{
+			MaxAttempts: 5,
+			BaseDelay:   30 * time.Second,
+			MaxDelay:    30 * time.Minute,
+			Jitter:      0.2,
+		}
+	}
+
+	if config.Logger == nil {
+		config.Logger = nopLogger{}
+	}
+
+	return &Queue{
+		mailer: mailer,
+		store:  store,
+		config: config,
+		now:    time.Now,
+	}, nil
+}
+
+// Enqueue validates and renders the message, and persists it for delivery to recipients. With
+// MERGE_MODE_LOCAL a job is stored per recipient, since each gets different content. The ids of the stored
+// jobs are returned.
+func (q *Queue) Enqueue(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]string, error) {
+
+	if message == nil {
+		return nil, errors.New("Enqueue: Must specify message;")
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("Enqueue: Must specify at least one recipient;")
+	}
+
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, err
+	}
+
+	if params == nil {
+		params = new(SendParams)
+	}
+
+	var jobs []*QueuedMail
+	if message.MergeMode == MERGE_MODE_LOCAL {
+		for i := range recipients {
+			job, err := q.newJob(recipients[i:i+1], message, params, recipientTemplateVars(message, &recipients[i]))
+			if err != nil {
+				return nil, err
+			}
+			job.Message.MergeMode = MERGE_MODE_NONE
+			jobs = append(jobs, job)
+		}
+	} else {
+		job, err := q.newJob(recipients, message, params, message.TemplateVars)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	ids := make([]string, len(jobs), len(jobs))
+	for i, job := range jobs {
+		if err := q.store.Save(job); err != nil {
+			return ids[:i], err
+		}
+		ids[i] = job.Id
+	}
+
+	return ids, nil
+}
+
+// newJob renders message with vars into a new job
+func (q *Queue) newJob(recipients []MailRecipient, message *MailMessage, params *SendParams, vars map[string]string) (*QueuedMail, error) {
+
+	html, text, err := renderMessageContent(message, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newQueueId()
+	if err != nil {
+		return nil, err
+	}
+
+	// every job gets its own idempotency key, kept across attempts, so that a Mailer with a dedup store won't
+	// send a job again once it has succeeded, e.g. when the job couldn't be deleted afterwards. The caller's
+	// key is shared by all the jobs of a MERGE_MODE_LOCAL send, so the job id is added to it.
+	p := *params
+	if p.IdempotencyKey == `` {
+		p.IdempotencyKey = id
+	} else {
+		p.IdempotencyKey += `:` + id
+	}
+
+	now := q.now()
+
+	return &QueuedMail{
+		Id:         id,
+		Recipients: append([]MailRecipient(nil), recipients...),
+		Message: QueuedMessage{
+			Html:            html,
+			Text:            text,
+			AutoText:        message.AutoText,
+			Subject:         message.Subject,
+			From:            message.From,
+			ReplyTo:         message.ReplyTo,
+			Attachments:     message.Attachments,
+			Images:          message.Images,
+			MarkImportant:   message.MarkImportant,
+			Tags:            message.Tags,
+			Metadata:        message.Metadata,
+			GlobalMergeVars: message.GlobalMergeVars,
+			MergeMode:       message.MergeMode,
+		},
+		Params:      p,
+		Created:     now,
+		NextAttempt: now,
+	}, nil
+}
+
+// ProcessReady attempts delivery of every job that is due, and returns the number delivered successfully.
+// Failed jobs are rescheduled or dead-lettered; only errors from the store are returned.
+func (q *Queue) ProcessReady(ctx context.Context) (int, error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs, err := q.store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	// oldest first
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+
+	var delivered int
+	for _, job := range jobs {
+
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+
+		if job.Dead || job.NextAttempt.After(q.now()) {
+			continue
+		}
+
+		ok, err := q.deliver(ctx, job)
+		if err != nil {
+			return delivered, err
+		}
+		if ok {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// Run calls ProcessReady every interval until ctx is done
+func (q *Queue) Run(ctx context.Context, interval time.Duration) error {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := q.ProcessReady(ctx); err != nil && ctx.Err() == nil {
+			q.config.Logger.Error(`mandrill: queue processing failed`, `error`, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeadLetters returns the jobs that failed permanently or ran out of attempts
+func (q *Queue) DeadLetters() ([]*QueuedMail, error) {
+
+	jobs, err := q.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var dead []*QueuedMail
+	for _, job := range jobs {
+		if job.Dead {
+			dead = append(dead, job)
+		}
+	}
+
+	return dead, nil
+}
+
+// Requeue moves a dead letter back into the queue for immediate delivery, with its attempts reset
+func (q *Queue) Requeue(id string) error {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs, err := q.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.Id == id && job.Dead {
+			job.Dead = false
+			job.Attempts = 0
+			job.NextAttempt = q.now()
+			return q.store.Save(job)
+		}
+	}
+
+	return errors.New("Requeue: no dead letter with id " + id)
+}
+
+// deliver sends a job and records the outcome in the store. It reports whether the job was delivered.
+func (q *Queue) deliver(ctx context.Context, job *QueuedMail) (bool, error) {
+
+	message := job.Message.mailMessage()
+
+	var (
+		resp []MailRecipientResponse
+		err  error
+	)
+	if mc, ok := q.mailer.(MailerContext); ok {
+		resp, err = mc.BulkMailContext(ctx, job.Recipients, message, &job.Params)
+	} else {
+		resp, err = q.mailer.BulkMail(job.Recipients, message, &job.Params)
+	}
+
+	if err == nil {
+		q.config.Logger.Debug(`mandrill: queued mail delivered`, `id`, job.Id, `statuses`, summarizeStatuses(resp))
+		return true, q.store.Delete(job.Id)
+	}
+
+	// only retry the recipients that weren't sent, so the others aren't sent twice
+	var partialErr partialError
+	if errors.As(err, &partialErr) {
+		job.Recipients = append([]MailRecipient(nil), partialErr.unsent()...)
+	}
+
+	job.LastError = err.Error()
+
+	// a send cut short because the queue is stopping isn't the job's fault, so it isn't counted as an attempt
+	// and the job stays due
+	if ctx.Err() != nil {
+		q.config.Logger.Debug(`mandrill: queued mail interrupted`, `id`, job.Id, `attempts`, job.Attempts, `error`, err)
+		return false, q.store.Save(job)
+	}
+
+	job.Attempts++
+
+	if !isRetryableQueueError(err) || job.Attempts >= q.config.Retry.MaxAttempts {
+		job.Dead = true
+		q.config.Logger.Error(`mandrill: queued mail dead-lettered`, `id`, job.Id, `attempts`, job.Attempts, `error`, err)
+		if err := q.store.Save(job); err != nil {
+			return false, err
+		}
+		if q.config.OnDeadLetter != nil {
+			q.config.OnDeadLetter(job)
+		}
+		return false, nil
+	}
+
+	job.NextAttempt = q.now().Add(q.config.Retry.delay(job.Attempts))
+	q.config.Logger.Debug(`mandrill: queued mail will be retried`, `id`, job.Id, `attempts`, job.Attempts, `next_attempt`, job.NextAttempt, `error`, err)
+
+	return false, q.store.Save(job)
+}
+
+// isRetryableQueueError reports whether a failed delivery is worth another attempt. API errors are retried
+// only when transient. Suppressed recipients and messages that fail validation would fail the same way again;
+// anything else, such as a network error, is assumed to be transient.
+func isRetryableQueueError(err error) bool {
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableError(apiErr)
+	}
+
+	var suppressionErr *SuppressionError
+	var validationErr *validationError
+	if errors.As(err, &suppressionErr) || errors.As(err, &validationErr) {
+		return false
+	}
+
+	return true
+}
+
+// mailMessage rebuilds a MailMessage whose templates reproduce the rendered content verbatim
+func (qm *QueuedMessage) mailMessage() *MailMessage {
+
+	msg := &MailMessage{
+		AutoText:        qm.AutoText,
+		Subject:         qm.Subject,
+		From:            qm.From,
+		ReplyTo:         qm.ReplyTo,
+		Attachments:     qm.Attachments,
+		Images:          qm.Images,
+		MarkImportant:   qm.MarkImportant,
+		Tags:            qm.Tags,
+		Metadata:        qm.Metadata,
+		GlobalMergeVars: qm.GlobalMergeVars,
+		MergeMode:       qm.MergeMode,
+	}
+
+	if qm.Html != `` || qm.Text == `` {
+		msg.HTMLTemplate = staticTemplate(qm.Html)
+	}
+	if qm.Text != `` {
+		msg.TextTemplate = staticTemplate(qm.Text)
+	}
+
+	return msg
+}
+
+// staticTemplate returns a template that outputs content unchanged. The content was already escaped when
+// it was first rendered, so it's marked safe to keep it from being escaped again.
+func staticTemplate(content string) *template.Template {
+
+	return template.Must(template.New(`queued`).Funcs(template.FuncMap{
+		`content`: func() template.HTML { return template.HTML(content) },
+	}).Parse(`{{content}}`))
+}
+
+// newQueueId returns a random job id
+func newQueueId() (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ``, err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryQueueStore is a QueueStore that keeps jobs in memory. Jobs don't survive a restart, so it's mainly
+// useful for tests and as the index of FileQueueStore.
+type MemoryQueueStore struct {
+	mu   sync.Mutex
+	jobs map[string]*QueuedMail
+}
+
+var _ QueueStore = new(MemoryQueueStore)
+
+// NewMemoryQueueStore creates an empty MemoryQueueStore
+func NewMemoryQueueStore() *MemoryQueueStore {
+	return &MemoryQueueStore{jobs: map[string]*QueuedMail{}}
+}
+
+func (s *MemoryQueueStore) Save(job *QueuedMail) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.Id] = copyQueuedMail(job)
+	return nil
+}
+
+func (s *MemoryQueueStore) Delete(id string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemoryQueueStore) List() ([]*QueuedMail, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]*QueuedMail, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, copyQueuedMail(job))
+	}
+
+	return jobs, nil
+}
+
+// copyQueuedMail makes a copy of job that shares no slices with it. Maps and attachments are treated as
+// read-only and are shared.
+func copyQueuedMail(job *QueuedMail) *QueuedMail {
+
+	c := *job
+	c.Recipients = append([]MailRecipient(nil), job.Recipients...)
+
+	return &c
+}
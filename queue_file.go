@@ -0,0 +1,112 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// queueLogEntry is one line of a FileQueueStore log. Job is set for saves and Id for deletes.
+type queueLogEntry struct {
+	Job *QueuedMail `json:"job,omitempty"`
+	Id  string      `json:"id,omitempty"`
+}
+
+// FileQueueStore is a QueueStore backed by an append-only JSON lines file. Every change is appended and
+// synced before it returns, and the file is replayed on open, so jobs survive a crash. Call Compact
+// periodically to drop superseded entries.
+type FileQueueStore struct {
+	mu    sync.Mutex
+	log   *jsonLog
+	index *MemoryQueueStore
+}
+
+var _ QueueStore = new(FileQueueStore)
+
+// OpenFileQueueStore opens, or creates, the store at path and loads its jobs. A torn final line, as left by
+// a crash mid-write, is discarded.
+func OpenFileQueueStore(path string) (*FileQueueStore, error) {
+
+	s := &FileQueueStore{
+		index: NewMemoryQueueStore(),
+	}
+
+	log, err := openJSONLog(path, `queue store`, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+
+	return s, nil
+}
+
+// replay applies one line of the log to the index
+func (s *FileQueueStore) replay(line []byte) error {
+
+	var entry queueLogEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return err
+	}
+
+	if entry.Job != nil {
+		return s.index.Save(entry.Job)
+	}
+
+	return s.index.Delete(entry.Id)
+}
+
+func (s *FileQueueStore) Save(job *QueuedMail) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.append(queueLogEntry{Job: job}); err != nil {
+		return err
+	}
+
+	return s.index.Save(job)
+}
+
+func (s *FileQueueStore) Delete(id string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.append(queueLogEntry{Id: id}); err != nil {
+		return err
+	}
+
+	return s.index.Delete(id)
+}
+
+func (s *FileQueueStore) List() ([]*QueuedMail, error) {
+	return s.index.List()
+}
+
+// Compact rewrites the log with only the current jobs. A crash while compacting leaves either the old log or
+// the new one intact.
+func (s *FileQueueStore) Compact() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs, err := s.index.List()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]interface{}, len(jobs))
+	for i, job := range jobs {
+		entries[i] = queueLogEntry{Job: job}
+	}
+
+	return s.log.rewrite(entries)
+}
+
+// Close closes the log file. The store can't be used afterwards.
+func (s *FileQueueStore) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.close()
+}
@@ -0,0 +1,245 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueue_DeliverAndRetry(t *testing.T) {
+
+	fake := NewFakeMailer()
+	fake.Err = errors.New(`network down`)
+
+	var dead []*QueuedMail
+	q, err := NewQueue(fake, NewMemoryQueueStore(), QueueConfig{
+		Retry:        &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Minute},
+		OnDeadLetter: func(job *QueuedMail) { dead = append(dead, job) },
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	message := newTestMessage()
+	message.HTMLTemplate = template.Must(template.New(`queue_test`).Parse(`<p>Hello {{.Name}} & co</p>`))
+	message.TemplateVars = map[string]string{`Name`: `<World>`}
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	ids, err := q.Enqueue(recipients, message, nil)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Enqueue failed : %v", err)
+	}
+
+	ctx := context.Background()
+	if n, err := q.ProcessReady(ctx); n != 0 || err != nil {
+		t.Fatalf("Expected the first attempt to fail, got %d delivered and %v", n, err)
+	}
+
+	// not due yet
+	fake.Err = nil
+	if n, _ := q.ProcessReady(ctx); n != 0 || len(fake.Messages()) != 0 {
+		t.Fatal("The job should wait for its backoff before the next attempt")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if n, err := q.ProcessReady(ctx); n != 1 || err != nil {
+		t.Fatalf("Expected the retry to deliver, got %d delivered and %v", n, err)
+	}
+
+	last := fake.LastMessage()
+	if last == nil || last.Html != `<p>Hello &lt;World&gt; & co</p>` {
+		t.Errorf("Queued content was not delivered verbatim : %+v", last)
+	}
+
+	if jobs, _ := q.store.List(); len(jobs) != 0 || len(dead) != 0 {
+		t.Errorf("Delivered jobs should be removed, found %d jobs and %d dead letters", len(jobs), len(dead))
+	}
+}
+
+func TestQueue_DeadLetter(t *testing.T) {
+
+	fake := NewFakeMailer()
+	fake.Err = &APIError{HTTPStatus: 500, Name: MANDRILL_ERROR_INVALID_KEY, Message: `Invalid API key`}
+
+	q, err := NewQueue(fake, NewMemoryQueueStore(), QueueConfig{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	ids, _ := q.Enqueue(recipients, newTestMessage(), nil)
+	q.ProcessReady(context.Background())
+
+	dead, _ := q.DeadLetters()
+	if len(dead) != 1 || dead[0].Attempts != 1 || dead[0].LastError == `` {
+		t.Fatalf("Permanent errors should dead-letter immediately, got %+v", dead)
+	}
+
+	fake.Err = nil
+	if err := q.Requeue(ids[0]); err != nil {
+		t.Fatal(err.Error())
+	}
+	if n, _ := q.ProcessReady(context.Background()); n != 1 {
+		t.Error("A requeued job should be delivered")
+	}
+}
+
+func TestQueue_DeadLetterSuppressed(t *testing.T) {
+
+	var requests int32
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}, WithSuppressionStore(NewMemorySuppressionStore(Suppression{Email: `to@example.com`}), SUPPRESSION_FAIL))
+
+	q, _ := NewQueue(m, NewMemoryQueueStore(), QueueConfig{})
+
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	q.Enqueue(recipients, newTestMessage(), nil)
+	q.ProcessReady(context.Background())
+
+	dead, _ := q.DeadLetters()
+	if len(dead) != 1 || dead[0].Attempts != 1 || atomic.LoadInt32(&requests) != 0 {
+		t.Fatalf("A suppressed job should dead-letter immediately without a request, got %+v", dead)
+	}
+}
+
+// interruptedMailer stands in for a send that is cut short by the queue stopping
+type interruptedMailer struct {
+	*FakeMailer
+	cancel context.CancelFunc
+}
+
+func (m *interruptedMailer) BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	m.cancel()
+	return m.FakeMailer.BulkMailContext(ctx, recipients, message, params)
+}
+
+func TestQueue_StopDoesNotCountAttempt(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store := NewMemoryQueueStore()
+	q, _ := NewQueue(&interruptedMailer{FakeMailer: NewFakeMailer(), cancel: cancel}, store, QueueConfig{})
+
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	q.Enqueue(recipients, newTestMessage(), nil)
+	q.ProcessReady(ctx)
+
+	jobs, _ := store.List()
+	if len(jobs) != 1 || jobs[0].Attempts != 0 || jobs[0].Dead || jobs[0].NextAttempt.After(time.Now()) {
+		t.Fatalf("An interrupted send shouldn't count as an attempt, got %+v", jobs)
+	}
+}
+
+func TestQueue_LocalMergeMode(t *testing.T) {
+
+	fake := NewFakeMailer()
+	q, _ := NewQueue(fake, NewMemoryQueueStore(), QueueConfig{})
+
+	message := newTestMessage()
+	message.HTMLTemplate = template.Must(template.New(`queue_test`).Parse(`<p>Hello {{.Name}} & co</p>`))
+	message.MergeMode = MERGE_MODE_LOCAL
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`Name`: `Ann`}},
+		{Email: `b@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`Name`: `Bob`}},
+	}
+
+	ids, err := q.Enqueue(recipients, message, nil)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("Expected a job per recipient, got %v and %v", ids, err)
+	}
+
+	q.ProcessReady(context.Background())
+	if len(fake.SentTo(`a@example.com`)) != 1 || fake.SentTo(`b@example.com`)[0].Html != `<p>Hello Bob & co</p>` {
+		t.Errorf("Unexpected per-recipient delivery : %+v", fake.Messages())
+	}
+}
+
+func TestQueue_LocalMergeIdempotency(t *testing.T) {
+
+	var requests int32
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+		w.Write([]byte(`[{"email":"` + p.Message.To[0].Email + `","status":"sent"}]`))
+	}, WithIdempotencyStore(NewMemoryIdempotencyStore(), time.Hour))
+
+	q, _ := NewQueue(m, NewMemoryQueueStore(), QueueConfig{})
+
+	message := newTestMessage()
+	message.MergeMode = MERGE_MODE_LOCAL
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+	}
+
+	// each recipient's job is sent, rather than the second reusing the first one's recorded responses
+	if _, err := q.Enqueue(recipients, message, &SendParams{IdempotencyKey: `newsletter-1`}); err != nil {
+		t.Fatalf("Enqueue failed : %s", err.Error())
+	}
+	if n, err := q.ProcessReady(context.Background()); n != 2 || err != nil || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Expected 2 jobs delivered with 2 requests, got %d, %d and %v", n, atomic.LoadInt32(&requests), err)
+	}
+}
+
+func TestFileQueueStore_Reopen(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `queue.jsonl`)
+
+	store, err := OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	store.Save(&QueuedMail{Id: `one`, Message: QueuedMessage{Subject: `first`}})
+	store.Save(&QueuedMail{Id: `two`})
+	store.Save(&QueuedMail{Id: `one`, Attempts: 1, Message: QueuedMessage{Subject: `first`}})
+	store.Delete(`two`)
+	store.Close()
+
+	// simulate a crash in the middle of a write
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte(`{"job":{"Id":"thr`))
+	f.Close()
+
+	store, err = OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatalf("Reopening after a torn write failed : %s", err.Error())
+	}
+	defer store.Close()
+
+	jobs, _ := store.List()
+	if len(jobs) != 1 || jobs[0].Id != `one` || jobs[0].Attempts != 1 || jobs[0].Message.Subject != `first` {
+		t.Fatalf("Unexpected jobs after reopen : %+v", jobs)
+	}
+
+	if err := store.Save(&QueuedMail{Id: `three`}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if jobs, _ := store.List(); len(jobs) != 2 {
+		t.Errorf("Expected 2 jobs after compaction, got %d", len(jobs))
+	}
+
+	store.Close()
+	store, err = OpenFileQueueStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	if jobs, _ := store.List(); len(jobs) != 2 {
+		t.Errorf("Expected 2 jobs after reopening the compacted log, got %d", len(jobs))
+	}
+}
@@ -0,0 +1,213 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitMode decides what a RateLimiter does when a send would exceed the limit
+type RateLimitMode int
+
+const (
+	// RATE_LIMIT_BLOCK waits until the send fits within the limit, or the context is done
+	RATE_LIMIT_BLOCK RateLimitMode = iota
+	// RATE_LIMIT_FAIL_FAST returns ErrRateLimited immediately
+	RATE_LIMIT_FAIL_FAST
+)
+
+// ErrRateLimited is returned, possibly wrapped, when a send is refused by the client-side RateLimiter
+var ErrRateLimited = errors.New("mandrill: client-side rate limit exceeded")
+
+// RateLimit configures a RateLimiter. A zero rate leaves that dimension unlimited.
+type RateLimit struct {
+	// RequestsPerSecond limits how often messages are submitted to the API
+	RequestsPerSecond float64
+	// Burst is the number of requests that may be made at once before RequestsPerSecond applies. It defaults
+	// to RequestsPerSecond rounded up.
+	Burst int
+	// RecipientsPerHour limits the total recipients across all sends, e.g. to stay under the account's hourly
+	// quota. A single send with more recipients than this always fails; see WithBatching.
+	RecipientsPerHour int
+	Mode              RateLimitMode
+}
+
+// RateLimitState is a snapshot of a RateLimiter, for schedulers that pace themselves
+type RateLimitState struct {
+	// AvailableRequests and AvailableRecipients are the tokens currently in each bucket. They are
+	// +Inf for dimensions that aren't limited.
+	AvailableRequests   float64
+	AvailableRecipients float64
+}
+
+// RateLimiter is a token-bucket limiter applied to every send made by the clients it is given to with
+// WithRateLimiter. One RateLimiter may be shared by several clients using the same account.
+type RateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+
+	mu         sync.Mutex
+	last       time.Time
+	requests   float64
+	recipients float64
+}
+
+// NewRateLimiter creates a RateLimiter with full buckets
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+
+	if limit.Burst < 1 {
+		limit.Burst = int(math.Ceil(limit.RequestsPerSecond))
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+	}
+
+	l := &RateLimiter{
+		limit: limit,
+		now:   time.Now,
+	}
+	l.last = l.now()
+	l.requests = float64(limit.Burst)
+	l.recipients = float64(limit.RecipientsPerHour)
+
+	return l
+}
+
+// WithRateLimiter passes every send through limiter before it is submitted
+func WithRateLimiter(limiter *RateLimiter) MandrillOption {
+	return func(m *mandrill) error {
+		m.limiter = limiter
+		return nil
+	}
+}
+
+// Wait takes the tokens for one request to the supplied number of recipients. In RATE_LIMIT_BLOCK mode it
+// waits for them to become available; in RATE_LIMIT_FAIL_FAST mode it returns ErrRateLimited instead. A nil
+// RateLimiter never limits.
+func (l *RateLimiter) Wait(ctx context.Context, recipients int) error {
+
+	if l == nil {
+		return nil
+	}
+
+	if l.limit.RecipientsPerHour > 0 && recipients > l.limit.RecipientsPerHour {
+		return fmt.Errorf("%w: %d recipients is more than the hourly limit of %d", ErrRateLimited, recipients, l.limit.RecipientsPerHour)
+	}
+
+	for {
+		delay := l.take(recipients)
+		if delay == 0 {
+			return nil
+		}
+
+		if l.limit.Mode == RATE_LIMIT_FAIL_FAST {
+			return fmt.Errorf("%w: retry in %s", ErrRateLimited, delay)
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// State returns the tokens currently available
+func (l *RateLimiter) State() RateLimitState {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	state := RateLimitState{
+		AvailableRequests:   math.Inf(1),
+		AvailableRecipients: math.Inf(1),
+	}
+	if l.limit.RequestsPerSecond > 0 {
+		state.AvailableRequests = l.requests
+	}
+	if l.limit.RecipientsPerHour > 0 {
+		state.AvailableRecipients = l.recipients
+	}
+
+	return state
+}
+
+// Delay returns how long until a send to the supplied number of recipients would be allowed, without taking
+// any tokens
+func (l *RateLimiter) Delay(recipients int) time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	return l.delay(recipients)
+}
+
+// take removes the tokens for a send if they are available, and otherwise returns how long to wait for them
+func (l *RateLimiter) take(recipients int) time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	if d := l.delay(recipients); d > 0 {
+		return d
+	}
+
+	if l.limit.RequestsPerSecond > 0 {
+		l.requests--
+	}
+	if l.limit.RecipientsPerHour > 0 {
+		l.recipients -= float64(recipients)
+	}
+
+	return 0
+}
+
+// delay calculates the wait for enough tokens in both buckets. Callers must hold l.mu.
+func (l *RateLimiter) delay(recipients int) time.Duration {
+
+	var wait float64
+
+	if l.limit.RequestsPerSecond > 0 && l.requests < 1 {
+		wait = (1 - l.requests) / l.limit.RequestsPerSecond
+	}
+
+	if l.limit.RecipientsPerHour > 0 && l.recipients < float64(recipients) {
+		perSecond := float64(l.limit.RecipientsPerHour) / 3600
+		wait = math.Max(wait, (float64(recipients)-l.recipients)/perSecond)
+	}
+
+	if wait == 0 {
+		return 0
+	}
+
+	// round up so the caller doesn't wake up just short of the next token
+	return time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// refill adds the tokens earned since the last refill. Callers must hold l.mu.
+func (l *RateLimiter) refill() {
+
+	now := l.now()
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	if elapsed <= 0 {
+		return
+	}
+
+	if l.limit.RequestsPerSecond > 0 {
+		l.requests = math.Min(float64(l.limit.Burst), l.requests+elapsed*l.limit.RequestsPerSecond)
+	}
+
+	if l.limit.RecipientsPerHour > 0 {
+		perSecond := float64(l.limit.RecipientsPerHour) / 3600
+		l.recipients = math.Min(float64(l.limit.RecipientsPerHour), l.recipients+elapsed*perSecond)
+	}
+}
@@ -0,0 +1,76 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Buckets(t *testing.T) {
+
+	now := time.Now()
+	l := NewRateLimiter(RateLimit{
+		RequestsPerSecond: 2,
+		RecipientsPerHour: 3600,
+		Mode:              RATE_LIMIT_FAIL_FAST,
+	})
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	ctx := context.Background()
+	if err := l.Wait(ctx, 10); err != nil {
+		t.Fatalf("First request should be allowed, got %s", err.Error())
+	}
+	if err := l.Wait(ctx, 10); err != nil {
+		t.Fatalf("Second request should be allowed by the burst, got %s", err.Error())
+	}
+
+	err := l.Wait(ctx, 10)
+	if !errors.Is(err, ErrRateLimited) || !IsRateLimited(err) {
+		t.Fatalf("Third request should be rate limited, got %v", err)
+	}
+
+	if d := l.Delay(10); d != 500*time.Millisecond {
+		t.Errorf("Expected a 500ms delay for the next request, got %s", d)
+	}
+
+	now = now.Add(time.Second)
+	state := l.State()
+	if state.AvailableRequests != 2 || state.AvailableRecipients != 3581 {
+		t.Errorf("Unexpected state after refill : %+v", state)
+	}
+
+	if err := l.Wait(ctx, 5000); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("A send larger than the hourly limit should always fail, got %v", err)
+	}
+}
+
+func TestRateLimiter_Blocking(t *testing.T) {
+
+	var requests int
+	limiter := NewRateLimiter(RateLimit{RequestsPerSecond: 50, Burst: 1})
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent","_id":"abc123"}]`))
+	}, WithRateLimiter(limiter))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`); err != nil {
+			t.Fatalf("SimpleMail failed with error : %s", err.Error())
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond || requests != 3 {
+		t.Errorf("Expected 3 requests paced over at least 40ms, got %d in %s", requests, elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.Wait(ctx, 1)
+	if err := limiter.Wait(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a blocked Wait to end with the context, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package mandrillmail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/mail"
+)
+
+// @see https://mailchimp.com/developer/transactional/api/messages/send-mime-message/
+
+type mandrillRawParams struct {
+	Key        string   `json:"key"`
+	RawMessage string   `json:"raw_message"`
+	FromEmail  string   `json:"from_email,omitempty"`
+	FromName   string   `json:"from_name,omitempty"`
+	To         []string `json:"to,omitempty"`
+	Async      bool     `json:"async"`
+	IpPool     string   `json:"ip_pool"`
+	SendAtTxt  string   `json:"send_at"`
+}
+
+// SendRaw sends a complete RFC 5322 / MIME message, such as one from ExportEML or a signed message built
+// elsewhere. from overrides the message's From header when set. to lists the envelope recipients; when it is
+// empty, Mandrill sends to the addresses in the To, Cc and Bcc headers. Suppressed recipients are left out
+// either way. Merge vars, tracking, tags and metadata don't apply to raw messages.
+func (m *mandrill) SendRaw(rawMessage []byte, from *MailRecipient, to []string, params *SendParams) ([]MailRecipientResponse, error) {
+	return m.SendRawContext(context.Background(), rawMessage, from, to, params)
+}
+
+// SendRawContext is SendRaw with a context that bounds the API call
+func (m *mandrill) SendRawContext(ctx context.Context, rawMessage []byte, from *MailRecipient, to []string, params *SendParams) ([]MailRecipientResponse, error) {
+
+	if len(rawMessage) == 0 {
+		return nil, errors.New("SendRaw: Must specify message;")
+	}
+
+	if params == nil {
+		params = new(SendParams)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(rawMessage))
+	if err != nil {
+		return nil, errors.New("SendRaw: Invalid message: " + err.Error() + ";")
+	}
+
+	// when to is empty the recipients come from the headers, as Mandrill's would, so that they can be checked
+	// for suppressions and counted for rate limiting
+	emails := to
+	if len(emails) == 0 {
+		emails = rawMessageRecipients(parsed.Header)
+	}
+	if len(emails) == 0 {
+		return nil, errors.New("SendRaw: Must specify at least one recipient;")
+	}
+
+	recipients := make([]MailRecipient, len(emails), len(emails))
+	for i, email := range emails {
+		recipients[i] = MailRecipient{Email: email, RecipientType: MAIL_TO}
+	}
+
+	send, suppressed, err := m.checkSuppressions(recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(send) == 0 {
+		return suppressed, nil
+	}
+
+	// Mandrill would still send to suppressed header recipients, so the rest are listed explicitly
+	if len(suppressed) > 0 {
+		to = make([]string, len(send), len(send))
+		for i, r := range send {
+			to[i] = r.Email
+		}
+	}
+
+	msg := &mandrillMessage{}
+	for _, r := range send {
+		msg.To = append(msg.To, mandrillRecipient{Email: r.Email, RecipientType: MAIL_TO})
+	}
+	if from != nil {
+		msg.FromEmail = from.Email
+		msg.FromName = from.Name
+	}
+
+	mandrillParams := m.buildParams(params, msg)
+	if err := mandrillParams.validate(); err != nil {
+		return nil, err
+	}
+
+	rawParams := &mandrillRawParams{
+		Key:        mandrillParams.Key,
+		RawMessage: string(rawMessage),
+		FromEmail:  msg.FromEmail,
+		FromName:   msg.FromName,
+		To:         to,
+		Async:      mandrillParams.Async,
+		IpPool:     mandrillParams.IpPool,
+		SendAtTxt:  mandrillParams.SendAtTxt,
+	}
+
+	return m.idempotent(ctx, params, func() ([]MailRecipientResponse, error) {
+		resp, err := m.deliver(ctx, MANDRILL_SEND_RAW_PATH, rawParams, mandrillParams)
+		return withDecided(recipients, send, resp, suppressed, err)
+	})
+}
+
+// rawMessageRecipients returns the addresses in the To, Cc and Bcc headers, skipping any that don't parse
+func rawMessageRecipients(header mail.Header) []string {
+
+	var emails []string
+	for _, name := range []string{`To`, `Cc`, `Bcc`} {
+		addrs, err := header.AddressList(name)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			emails = append(emails, a.Address)
+		}
+	}
+
+	return emails
+}
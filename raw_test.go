@@ -0,0 +1,90 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRawMessage = "From: Invoices <invoices@example.com>\r\n" +
+	"To: customer@example.com\r\n" +
+	"Cc: Accounts <accounts@example.com>\r\n" +
+	"Subject: Invoice\r\n" +
+	"\r\n" +
+	"Signed invoice attached.\r\n"
+
+func TestMandrill_SendRaw(t *testing.T) {
+
+	var (
+		path   string
+		params map[string]interface{}
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&params)
+		w.Write([]byte(`[{"email":"customer@example.com","status":"scheduled","_id":"abc123"}]`))
+	})
+
+	sendAt := time.Date(2024, 3, 3, 20, 30, 0, 0, time.FixedZone(`UTC+2`, 2*60*60))
+	resp, err := m.SendRaw([]byte(testRawMessage), &MailRecipient{Name: `Billing`, Email: `billing@example.com`},
+		[]string{`customer@example.com`}, &SendParams{SendAt: &sendAt, IpPool: `Transactional`, SendAsync: true})
+	if err != nil {
+		t.Fatalf("SendRaw failed with error : %s", err.Error())
+	}
+
+	if path != MANDRILL_SEND_RAW_PATH {
+		t.Errorf("Expected request to %s, got %s", MANDRILL_SEND_RAW_PATH, path)
+	}
+	if len(resp) != 1 || resp[0].Status != MAIL_MESSAGE_SCHEDULED || resp[0].Id != `abc123` {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	if params[`raw_message`] != testRawMessage || params[`from_email`] != `billing@example.com` ||
+		params[`from_name`] != `Billing` || params[`ip_pool`] != `Transactional` || params[`async`] != true ||
+		params[`send_at`] != `2024-03-03 18:30:00` || params[`key`] != `local-test-key` {
+		t.Errorf("Unexpected params %v", params)
+	}
+	if to, ok := params[`to`].([]interface{}); !ok || len(to) != 1 || to[0] != `customer@example.com` {
+		t.Errorf("Unexpected to %v", params[`to`])
+	}
+	if _, ok := params[`message`]; ok {
+		t.Errorf("Expected no message object for a raw send")
+	}
+}
+
+func TestMandrill_SendRawFromHeaders(t *testing.T) {
+
+	var params map[string]interface{}
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&params)
+		w.Write([]byte(`[{"email":"customer@example.com","status":"sent"},{"email":"accounts@example.com","status":"sent"}]`))
+	}, WithRateLimiter(NewRateLimiter(RateLimit{RecipientsPerHour: 1, Mode: RATE_LIMIT_FAIL_FAST})))
+
+	// the recipients in the headers count against the rate limit, even though they aren't listed
+	if _, err := m.SendRaw([]byte(testRawMessage), nil, nil, nil); !IsRateLimited(err) {
+		t.Errorf("Expected a rate limit error for two recipients, got %v", err)
+	}
+
+	m.limiter = nil
+	resp, err := m.SendRaw([]byte(testRawMessage), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("SendRaw failed with error : %s", err.Error())
+	}
+	if len(resp) != 2 {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+	for _, name := range []string{`to`, `from_email`, `from_name`} {
+		if _, ok := params[name]; ok {
+			t.Errorf("Expected %s to be left for Mandrill to take from the headers, got %v", name, params)
+		}
+	}
+
+	if _, err := m.SendRaw(nil, nil, nil, nil); err == nil {
+		t.Errorf("Expected an error for an empty message")
+	}
+	if _, err := m.SendRaw([]byte("Subject: No recipients\r\n\r\nBody"), nil, nil, nil); err == nil || !strings.Contains(err.Error(), `recipient`) {
+		t.Errorf("Expected an error for a message without recipients, got %v", err)
+	}
+}
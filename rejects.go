@@ -0,0 +1,413 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// @see https://mailchimp.com/developer/transactional/api/rejects/
+
+// RejectEntry is an address on the account's reject list. Mandrill rejects mail to it until it expires or
+// is deleted.
+type RejectEntry struct {
+	Email       string
+	Reason      RejectReason
+	Detail      string
+	CreatedAt   time.Time
+	LastEventAt time.Time
+	// ExpiresAt is zero for entries that never expire
+	ExpiresAt  time.Time
+	Expired    bool
+	Subaccount string
+}
+
+// AllowlistEntry is an address that Mandrill will never add to the reject list
+type AllowlistEntry struct {
+	Email     string
+	Detail    string
+	CreatedAt time.Time
+}
+
+type mandrillRejectEntry struct {
+	Email       string       `json:"email"`
+	Reason      RejectReason `json:"reason"`
+	Detail      string       `json:"detail"`
+	CreatedAt   string       `json:"created_at"`
+	LastEventAt string       `json:"last_event_at"`
+	ExpiresAt   string       `json:"expires_at"`
+	Expired     bool         `json:"expired"`
+	Subaccount  string       `json:"subaccount"`
+}
+
+type mandrillAllowlistEntry struct {
+	Email     string `json:"email"`
+	Detail    string `json:"detail"`
+	CreatedAt string `json:"created_at"`
+}
+
+type mandrillEmailParams struct {
+	Key     string `json:"key"`
+	Email   string `json:"email,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type mandrillListRejectsParams struct {
+	Key            string `json:"key"`
+	Email          string `json:"email,omitempty"`
+	IncludeExpired bool   `json:"include_expired"`
+}
+
+// mandrillListResult is the response to adding an address to, or deleting it from, a list
+type mandrillListResult struct {
+	Email   string `json:"email"`
+	Added   bool   `json:"added"`
+	Deleted bool   `json:"deleted"`
+}
+
+// AddReject adds email to the reject list, so that Mandrill rejects mail to it. It reports whether the
+// address was added, which is false if it was already on the list.
+func (m *mandrill) AddReject(email, comment string) (bool, error) {
+	return m.AddRejectContext(context.Background(), email, comment)
+}
+
+// AddRejectContext is AddReject with a context that bounds the API call
+func (m *mandrill) AddRejectContext(ctx context.Context, email, comment string) (bool, error) {
+
+	email = strings.TrimSpace(email)
+	if email == `` {
+		return false, errors.New("AddReject: Must specify email address;")
+	}
+
+	var result mandrillListResult
+	if err := m.call(ctx, MANDRILL_REJECTS_ADD_PATH, &mandrillEmailParams{Key: m.key, Email: email, Comment: comment}, &result); err != nil {
+		return false, err
+	}
+
+	m.rejects.add(RejectEntry{
+		Email:     email,
+		Reason:    MAIL_REJECT_CUSTOM,
+		Detail:    comment,
+		CreatedAt: time.Now().UTC(),
+	})
+
+	return result.Added, nil
+}
+
+// ListRejects returns the reject list, or the entry for one address if email is set. Mandrill returns at
+// most 1000 entries.
+func (m *mandrill) ListRejects(email string, includeExpired bool) ([]RejectEntry, error) {
+	return m.ListRejectsContext(context.Background(), email, includeExpired)
+}
+
+// ListRejectsContext is ListRejects with a context that bounds the API call
+func (m *mandrill) ListRejectsContext(ctx context.Context, email string, includeExpired bool) ([]RejectEntry, error) {
+
+	params := &mandrillListRejectsParams{
+		Key:            m.key,
+		Email:          strings.TrimSpace(email),
+		IncludeExpired: includeExpired,
+	}
+
+	var results []mandrillRejectEntry
+	if err := m.call(ctx, MANDRILL_REJECTS_LIST_PATH, params, &results); err != nil {
+		return nil, err
+	}
+
+	entries := make([]RejectEntry, len(results), len(results))
+	for i, v := range results {
+
+		entries[i] = RejectEntry{
+			Email:      v.Email,
+			Reason:     v.Reason,
+			Detail:     v.Detail,
+			Expired:    v.Expired,
+			Subaccount: v.Subaccount,
+		}
+
+		var err error
+		if entries[i].CreatedAt, err = parseMandrillTime(v.CreatedAt); err != nil {
+			return nil, err
+		}
+		if entries[i].LastEventAt, err = parseMandrillTime(v.LastEventAt); err != nil {
+			return nil, err
+		}
+		if entries[i].ExpiresAt, err = parseMandrillTime(v.ExpiresAt); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// DeleteReject removes email from the reject list, so that mail to it is sent again. It reports whether
+// the address was deleted, which is false if it wasn't on the list.
+func (m *mandrill) DeleteReject(email string) (bool, error) {
+	return m.DeleteRejectContext(context.Background(), email)
+}
+
+// DeleteRejectContext is DeleteReject with a context that bounds the API call
+func (m *mandrill) DeleteRejectContext(ctx context.Context, email string) (bool, error) {
+
+	email = strings.TrimSpace(email)
+	if email == `` {
+		return false, errors.New("DeleteReject: Must specify email address;")
+	}
+
+	var result mandrillListResult
+	if err := m.call(ctx, MANDRILL_REJECTS_DELETE_PATH, &mandrillEmailParams{Key: m.key, Email: email}, &result); err != nil {
+		return false, err
+	}
+
+	m.rejects.delete(email)
+
+	return result.Deleted, nil
+}
+
+// AddAllowlist adds email to the allowlist, so that bounces and complaints never add it to the reject list.
+// It reports whether the address was added.
+func (m *mandrill) AddAllowlist(email, comment string) (bool, error) {
+	return m.AddAllowlistContext(context.Background(), email, comment)
+}
+
+// AddAllowlistContext is AddAllowlist with a context that bounds the API call
+func (m *mandrill) AddAllowlistContext(ctx context.Context, email, comment string) (bool, error) {
+
+	email = strings.TrimSpace(email)
+	if email == `` {
+		return false, errors.New("AddAllowlist: Must specify email address;")
+	}
+
+	var result mandrillListResult
+	if err := m.call(ctx, MANDRILL_ALLOWLISTS_ADD_PATH, &mandrillEmailParams{Key: m.key, Email: email, Comment: comment}, &result); err != nil {
+		return false, err
+	}
+
+	return result.Added, nil
+}
+
+// ListAllowlist returns the allowlist, or the entry for one address if email is set
+func (m *mandrill) ListAllowlist(email string) ([]AllowlistEntry, error) {
+	return m.ListAllowlistContext(context.Background(), email)
+}
+
+// ListAllowlistContext is ListAllowlist with a context that bounds the API call
+func (m *mandrill) ListAllowlistContext(ctx context.Context, email string) ([]AllowlistEntry, error) {
+
+	var results []mandrillAllowlistEntry
+	if err := m.call(ctx, MANDRILL_ALLOWLISTS_LIST_PATH, &mandrillEmailParams{Key: m.key, Email: strings.TrimSpace(email)}, &results); err != nil {
+		return nil, err
+	}
+
+	entries := make([]AllowlistEntry, len(results), len(results))
+	for i, v := range results {
+
+		created, err := parseMandrillTime(v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[i] = AllowlistEntry{
+			Email:     v.Email,
+			Detail:    v.Detail,
+			CreatedAt: created,
+		}
+	}
+
+	return entries, nil
+}
+
+// DeleteAllowlist removes email from the allowlist. It reports whether the address was deleted.
+func (m *mandrill) DeleteAllowlist(email string) (bool, error) {
+	return m.DeleteAllowlistContext(context.Background(), email)
+}
+
+// DeleteAllowlistContext is DeleteAllowlist with a context that bounds the API call
+func (m *mandrill) DeleteAllowlistContext(ctx context.Context, email string) (bool, error) {
+
+	email = strings.TrimSpace(email)
+	if email == `` {
+		return false, errors.New("DeleteAllowlist: Must specify email address;")
+	}
+
+	var result mandrillListResult
+	if err := m.call(ctx, MANDRILL_ALLOWLISTS_DELETE_PATH, &mandrillEmailParams{Key: m.key, Email: email}, &result); err != nil {
+		return false, err
+	}
+
+	return result.Deleted, nil
+}
+
+// WithRejectCheck makes BulkMail skip recipients on the account's reject list, using a local copy of the
+// list that is reloaded when it's older than maxAge. Skipped recipients are reported as
+// MAIL_MESSAGE_REJECTED without being sent to Mandrill, so they don't count against the account's quota.
+// Since Mandrill lists at most 1000 rejects, larger lists are only partly checked; Mandrill still rejects
+// the rest itself.
+func WithRejectCheck(maxAge time.Duration) MandrillOption {
+	return func(m *mandrill) error {
+		if maxAge <= 0 {
+			return errors.New("WithRejectCheck: maxAge must be positive")
+		}
+		m.rejects = &rejectCache{
+			maxAge: maxAge,
+			now:    time.Now,
+		}
+		return nil
+	}
+}
+
+// rejectCache is the local copy of the reject list used by WithRejectCheck. A nil cache disables the
+// check.
+type rejectCache struct {
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	loaded  time.Time
+	entries map[string]RejectEntry
+	// loading is the reload in progress, if any
+	loading *rejectLoad
+}
+
+// rejectLoad is a reload of the reject cache, shared by every caller that needs it while it runs
+type rejectLoad struct {
+	done chan struct{}
+	err  error
+}
+
+// RefreshRejects reloads the local copy of the reject list used by WithRejectCheck. The copy is also
+// reloaded automatically when it's stale, but calling this at startup avoids a delay on the first send.
+func (m *mandrill) RefreshRejects(ctx context.Context) error {
+
+	if m.rejects == nil {
+		return errors.New("RefreshRejects: reject check is not enabled;")
+	}
+
+	return m.refreshRejects(ctx)
+}
+
+// refreshRejects reloads the reject cache. The list is fetched without holding m.rejects.mu, so sends that
+// find the copy fresh aren't held up, and a caller that finds a reload in progress waits for its result
+// instead of starting another.
+func (m *mandrill) refreshRejects(ctx context.Context) error {
+
+	c := m.rejects
+	c.mu.Lock()
+	if load := c.loading; load != nil {
+		c.mu.Unlock()
+		select {
+		case <-load.done:
+			return load.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	load := &rejectLoad{done: make(chan struct{})}
+	c.loading = load
+	c.mu.Unlock()
+
+	list, err := m.ListRejectsContext(ctx, ``, false)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		entries := make(map[string]RejectEntry, len(list))
+		for _, v := range list {
+			entries[strings.ToLower(v.Email)] = v
+		}
+		c.entries = entries
+		c.loaded = c.now()
+	}
+
+	load.err = err
+	c.loading = nil
+	close(load.done)
+
+	return err
+}
+
+// skipRejected splits recipients into those to send and responses for those on the reject list. If the
+// list can't be reloaded, the stale copy is used and Mandrill remains the final check.
+func (m *mandrill) skipRejected(ctx context.Context, recipients []MailRecipient) ([]MailRecipient, []MailRecipientResponse) {
+
+	if m.rejects == nil {
+		return recipients, nil
+	}
+
+	c := m.rejects
+	c.mu.Lock()
+	stale := c.loaded.IsZero() || c.now().Sub(c.loaded) >= c.maxAge
+	c.mu.Unlock()
+
+	// a failed reload isn't retried until the copy would have gone stale again, so that an outage doesn't
+	// add a failing API call to every send
+	if stale {
+		if err := m.refreshRejects(ctx); err != nil {
+			m.logger.Error(`mandrill: failed to refresh reject list`, `error`, err)
+			c.mu.Lock()
+			c.loaded = c.now()
+			c.mu.Unlock()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		send    = make([]MailRecipient, 0, len(recipients))
+		skipped []MailRecipientResponse
+		now     = c.now()
+	)
+	for _, r := range recipients {
+
+		entry, ok := c.entries[strings.ToLower(r.Email)]
+		if !ok || entry.Expired || (!entry.ExpiresAt.IsZero() && now.After(entry.ExpiresAt)) {
+			send = append(send, r)
+			continue
+		}
+
+		skipped = append(skipped, MailRecipientResponse{
+			Email:        r.Email,
+			Status:       MAIL_MESSAGE_REJECTED,
+			RejectReason: entry.Reason,
+			Error:        string(entry.Reason),
+		})
+	}
+
+	if len(skipped) > 0 {
+		m.logger.Debug(`mandrill: skipped rejected recipients`, `skipped`, len(skipped))
+	}
+
+	return send, skipped
+}
+
+// add records a new reject, if the cache is enabled and loaded
+func (c *rejectCache) add(entry RejectEntry) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries != nil {
+		c.entries[strings.ToLower(entry.Email)] = entry
+	}
+}
+
+// delete forgets a reject, if the cache is enabled
+func (c *rejectCache) delete(email string) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, strings.ToLower(email))
+}
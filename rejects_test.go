@@ -0,0 +1,220 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMandrill_RejectsAndAllowlists(t *testing.T) {
+
+	var (
+		paths  []string
+		params []map[string]interface{}
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&p)
+		paths = append(paths, r.URL.Path)
+		params = append(params, p)
+
+		switch r.URL.Path {
+		case MANDRILL_REJECTS_ADD_PATH, MANDRILL_ALLOWLISTS_ADD_PATH:
+			w.Write([]byte(`{"email":"to@example.com","added":true}`))
+		case MANDRILL_REJECTS_DELETE_PATH, MANDRILL_ALLOWLISTS_DELETE_PATH:
+			w.Write([]byte(`{"email":"to@example.com","deleted":true}`))
+		case MANDRILL_REJECTS_LIST_PATH:
+			w.Write([]byte(`[{"email":"to@example.com","reason":"hard-bounce","detail":"550 mailbox does not exist",
+				"created_at":"2024-03-01 09:00:00","last_event_at":"2024-03-01 09:00:00","expires_at":"","expired":false}]`))
+		case MANDRILL_ALLOWLISTS_LIST_PATH:
+			w.Write([]byte(`[{"email":"vip@example.com","detail":"Added manually","created_at":"2024-03-01 09:00:00"}]`))
+		}
+	})
+
+	if added, err := m.AddReject(`to@example.com`, `asked to stop`); err != nil || !added {
+		t.Fatalf("AddReject returned %v, %v", added, err)
+	}
+	if params[0][`email`] != `to@example.com` || params[0][`comment`] != `asked to stop` {
+		t.Errorf("Unexpected add params %v", params[0])
+	}
+
+	rejects, err := m.ListRejects(``, true)
+	if err != nil {
+		t.Fatalf("ListRejects failed with error : %s", err.Error())
+	}
+	if len(rejects) != 1 || rejects[0].Reason != MAIL_REJECT_HARD_BOUNCE || !rejects[0].ExpiresAt.IsZero() {
+		t.Errorf("Unexpected rejects %+v", rejects)
+	}
+	if !rejects[0].CreatedAt.Equal(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected created time %s", rejects[0].CreatedAt)
+	}
+	if params[1][`include_expired`] != true {
+		t.Errorf("Expected include_expired, got %v", params[1])
+	}
+
+	if deleted, err := m.DeleteReject(`to@example.com`); err != nil || !deleted {
+		t.Errorf("DeleteReject returned %v, %v", deleted, err)
+	}
+
+	if added, err := m.AddAllowlist(`vip@example.com`, ``); err != nil || !added {
+		t.Errorf("AddAllowlist returned %v, %v", added, err)
+	}
+
+	allowlist, err := m.ListAllowlist(``)
+	if err != nil {
+		t.Fatalf("ListAllowlist failed with error : %s", err.Error())
+	}
+	if len(allowlist) != 1 || allowlist[0].Email != `vip@example.com` {
+		t.Errorf("Unexpected allowlist %+v", allowlist)
+	}
+
+	if deleted, err := m.DeleteAllowlist(`vip@example.com`); err != nil || !deleted {
+		t.Errorf("DeleteAllowlist returned %v, %v", deleted, err)
+	}
+
+	want := []string{MANDRILL_REJECTS_ADD_PATH, MANDRILL_REJECTS_LIST_PATH, MANDRILL_REJECTS_DELETE_PATH,
+		MANDRILL_ALLOWLISTS_ADD_PATH, MANDRILL_ALLOWLISTS_LIST_PATH, MANDRILL_ALLOWLISTS_DELETE_PATH}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Expected request %d to %s, got %s", i, want[i], paths[i])
+		}
+	}
+
+	if _, err := m.AddReject(` `, ``); err == nil {
+		t.Errorf("Expected an error for a missing email")
+	}
+}
+
+func TestMandrill_RejectCheck(t *testing.T) {
+
+	var (
+		mu    sync.Mutex
+		lists int
+		sent  [][]string
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case MANDRILL_REJECTS_LIST_PATH:
+			lists++
+			w.Write([]byte(`[{"email":"Bounced@example.com","reason":"hard-bounce","created_at":"2024-03-01 09:00:00"},
+				{"email":"expired@example.com","reason":"soft-bounce","expires_at":"2000-01-01 00:00:00"}]`))
+		case MANDRILL_REJECTS_ADD_PATH:
+			w.Write([]byte(`{"email":"unsub@example.com","added":true}`))
+		default:
+			var p mandrillParams
+			json.NewDecoder(r.Body).Decode(&p)
+			var to []string
+			resp := `[`
+			for i, v := range p.Message.To {
+				to = append(to, v.Email)
+				if i > 0 {
+					resp += `,`
+				}
+				resp += `{"email":"` + v.Email + `","status":"sent"}`
+			}
+			sent = append(sent, to)
+			w.Write([]byte(resp + `]`))
+		}
+	}, WithRejectCheck(time.Hour))
+
+	now := time.Now()
+	m.rejects.now = func() time.Time { return now }
+
+	message := newTestMessage()
+	recipients := []MailRecipient{
+		{Email: `to@example.com`, RecipientType: MAIL_TO},
+		{Email: `bounced@example.com`, RecipientType: MAIL_TO},
+		{Email: `expired@example.com`, RecipientType: MAIL_TO},
+	}
+
+	resp, err := m.BulkMail(recipients, message, new(SendParams))
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if len(sent) != 1 || len(sent[0]) != 2 || sent[0][0] != `to@example.com` || sent[0][1] != `expired@example.com` {
+		t.Fatalf("Expected the rejected recipient to be skipped, sent %v", sent)
+	}
+	if len(resp) != 3 || resp[1].Email != `bounced@example.com` || resp[1].Status != MAIL_MESSAGE_REJECTED ||
+		resp[1].RejectReason != MAIL_REJECT_HARD_BOUNCE || resp[2].Email != `expired@example.com` {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	// the list isn't reloaded until it's stale, but local changes are applied straight away
+	m.AddReject(`unsub@example.com`, ``)
+	resp, err = m.BulkMail([]MailRecipient{{Email: `unsub@example.com`, RecipientType: MAIL_TO}}, message, new(SendParams))
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+	if lists != 1 || len(sent) != 1 || len(resp) != 1 || resp[0].RejectReason != MAIL_REJECT_CUSTOM {
+		t.Errorf("Expected a cached skip, got %d lists, %d sends, %+v", lists, len(sent), resp)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := m.BulkMail(recipients[:1], message, new(SendParams)); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+	if lists != 2 {
+		t.Errorf("Expected the stale list to be reloaded, got %d lists", lists)
+	}
+
+	if _, err := NewMandrill(`key`, `example.com`, message.From, http.DefaultClient, WithRejectCheck(0)); err == nil {
+		t.Errorf("Expected an error for a zero maxAge")
+	}
+}
+
+func TestMandrill_RejectCheckFailure(t *testing.T) {
+
+	var (
+		mu    sync.Mutex
+		lists int
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == MANDRILL_REJECTS_LIST_PATH {
+			mu.Lock()
+			lists++
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			w.Write([]byte(`[{"email":"bounced@example.com","reason":"hard-bounce"}]`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"error","code":-99,"name":"ServiceUnavailable","message":"Try again"}`))
+	}, WithRejectCheck(time.Hour))
+
+	message := newTestMessage()
+	recipients := []MailRecipient{
+		{Email: `bounced@example.com`, RecipientType: MAIL_TO},
+		{Email: `to@example.com`, RecipientType: MAIL_TO},
+	}
+
+	// concurrent sends share one reload of the list, and each keeps the skipped response when its send fails
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := m.BulkMail(recipients, message, new(SendParams))
+
+			var unsentErr *UnsentError
+			if !errors.As(err, &unsentErr) || len(unsentErr.Recipients) != 1 || unsentErr.Recipients[0].Email != `to@example.com` {
+				t.Errorf("Expected an UnsentError for the sent recipient, got %v", err)
+			}
+			if len(resp) != 2 || resp[0].Status != MAIL_MESSAGE_REJECTED || resp[1].Status != MAIL_MESSAGE_UNKNOWN {
+				t.Errorf("Unexpected responses %+v", resp)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lists != 1 {
+		t.Errorf("Expected the list to be loaded once, got %d", lists)
+	}
+}
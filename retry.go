@@ -0,0 +1,136 @@
+package mandrillmail
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed API calls are retried. Network errors, 5xx responses that aren't
+// permanent Mandrill errors, and rate-limit errors are retried with exponential backoff. A call is never
+// retried once Mandrill has returned a successful response, so a message can't be sent twice by the client.
+// Nor is a send retried when it fails after its request was written, e.g. by timing out, as Mandrill may
+// already have accepted it.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 1 are treated as 1.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles on each subsequent retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter is the fraction (0 to 1) of each delay that is randomized, to keep many clients from retrying in step
+	Jitter float64
+	// OnAttempt, if set, is called after every attempt that fails
+	OnAttempt func(attempt RetryAttempt)
+}
+
+// RetryAttempt describes a failed attempt, as reported to RetryPolicy.OnAttempt
+type RetryAttempt struct {
+	// Attempt is the 1-based number of the attempt that failed
+	Attempt int
+	Err     error
+	// WillRetry reports whether another attempt will be made, after waiting Delay
+	WillRetry bool
+	Delay     time.Duration
+}
+
+// DefaultRetryPolicy returns a RetryPolicy suitable for most applications: three attempts, starting at
+// 500ms and backing off to at most 5s, with 20% jitter.
+func DefaultRetryPolicy() *RetryPolicy {
+
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// WithRetryPolicy retries failed API calls according to policy. Without it, every call is attempted once.
+func WithRetryPolicy(policy *RetryPolicy) MandrillOption {
+	return func(m *mandrill) error {
+		m.retryPolicy = policy
+		return nil
+	}
+}
+
+// do runs fn until it succeeds, returns a non-retryable error, or the attempts are used up. A nil policy
+// runs fn exactly once.
+func (p *RetryPolicy) do(ctx context.Context, fn func() (bool, error)) error {
+
+	for attempt := 1; ; attempt++ {
+
+		retryable, err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if p == nil {
+			return err
+		}
+
+		report := RetryAttempt{
+			Attempt:   attempt,
+			Err:       err,
+			WillRetry: retryable && attempt < p.MaxAttempts,
+		}
+		if report.WillRetry {
+			report.Delay = p.delay(attempt)
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(report)
+		}
+
+		if !report.WillRetry {
+			return err
+		}
+
+		if err := sleepContext(ctx, report.Delay); err != nil {
+			return err
+		}
+	}
+}
+
+// delay calculates the backoff before the retry that follows the supplied attempt
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+
+	d := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+
+	return d
+}
+
+// sleepContext waits for d, returning early with the context's error if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
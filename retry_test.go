@@ -0,0 +1,149 @@
+package mandrillmail
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_RetriesTransientErrors(t *testing.T) {
+
+	var (
+		requests int
+		attempts []RetryAttempt
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`upstream unavailable`))
+			return
+		}
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent","_id":"abc123"}]`))
+	}, WithRetryPolicy(&RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		OnAttempt: func(a RetryAttempt) {
+			attempts = append(attempts, a)
+		},
+	}))
+
+	response, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`)
+	if err != nil {
+		t.Fatalf("SimpleMail failed with error : %s", err.Error())
+	}
+
+	if response.Status != MAIL_MESSAGE_SENT || requests != 3 {
+		t.Errorf("Expected 3 requests and a sent status, got %d requests and %s", requests, response.Status)
+	}
+
+	if len(attempts) != 2 || !attempts[0].WillRetry || attempts[1].Attempt != 2 {
+		t.Errorf("Unexpected retry attempts reported : %+v", attempts)
+	}
+}
+
+func TestRetryPolicy_PermanentErrors(t *testing.T) {
+
+	var requests int
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":"error","code":-1,"name":"Invalid_Key","message":"Invalid API key"}`))
+	}, WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+
+	_, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`)
+	if !IsInvalidKey(err) {
+		t.Errorf("Expected an invalid key error, got %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("Permanent errors should not be retried, but %d requests were made", requests)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, v := range expected {
+		if d := p.delay(i + 1); d != v {
+			t.Errorf("Expected delay %s after attempt %d, got %s", v, i+1, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if d := p.delay(1); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Errorf("Jittered delay %s out of range", d)
+		}
+	}
+}
+
+func TestRetryPolicy_RateLimited(t *testing.T) {
+
+	var requests int
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`upstream unavailable`))
+	},
+		WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}),
+		WithRateLimiter(NewRateLimiter(RateLimit{RequestsPerSecond: 0.01, Burst: 1, Mode: RATE_LIMIT_FAIL_FAST})))
+
+	// the retry needs a second request token, which the limiter doesn't have
+	_, err := m.SimpleMail(`from@example.com`, `to@example.com`, `Test Mail`, `This is a test!`)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected the retry to be rate limited, got %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
+
+func TestRetryPolicy_FailuresAfterWrite(t *testing.T) {
+
+	var (
+		requests int32
+		hangUp   int32
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&hangUp) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`[{"email":"to@example.com","status":"sent","_id":"abc123"}]`))
+	}, WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	m.client.Timeout = 20 * time.Millisecond
+
+	recipients := []MailRecipient{{Email: `to@example.com`, RecipientType: MAIL_TO}}
+	message := newTestMessage()
+
+	// Mandrill may have accepted a send that timed out or lost its connection, so it isn't repeated, even with
+	// an idempotency key
+	for _, params := range []*SendParams{{}, {IdempotencyKey: `timeout-1`}} {
+		atomic.StoreInt32(&requests, 0)
+		if _, err := m.BulkMail(recipients, message, params); err == nil || atomic.LoadInt32(&requests) != 1 {
+			t.Errorf("Expected 1 request and an error, got %d and %v", atomic.LoadInt32(&requests), err)
+		}
+	}
+
+	atomic.StoreInt32(&hangUp, 1)
+	atomic.StoreInt32(&requests, 0)
+	if _, err := m.BulkMail(recipients, message, &SendParams{}); err == nil || atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Expected 1 request and an error, got %d and %v", atomic.LoadInt32(&requests), err)
+	}
+
+	// other calls can always be repeated
+	atomic.StoreInt32(&hangUp, 0)
+	atomic.StoreInt32(&requests, 0)
+	if _, err := m.MessageInfo(`abc123`); err == nil || atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("Expected 3 requests and an error, got %d and %v", atomic.LoadInt32(&requests), err)
+	}
+}
@@ -0,0 +1,144 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// @see https://mailchimp.com/developer/transactional/api/messages/list-scheduled-emails/
+
+// ScheduledMessage is a message sent with SendParams.SendAt that hasn't been delivered yet
+type ScheduledMessage struct {
+	// Id is the Id from the message's MailRecipientResponse
+	Id        string
+	CreatedAt time.Time
+	SendAt    time.Time
+	FromEmail string
+	To        string
+	Subject   string
+}
+
+type mandrillScheduledMessage struct {
+	Id        string `json:"_id"`
+	CreatedAt string `json:"created_at"`
+	SendAt    string `json:"send_at"`
+	FromEmail string `json:"from_email"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+}
+
+func (sm *mandrillScheduledMessage) scheduledMessage() (*ScheduledMessage, error) {
+
+	created, err := parseMandrillTime(sm.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	sendAt, err := parseMandrillTime(sm.SendAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScheduledMessage{
+		Id:        sm.Id,
+		CreatedAt: created,
+		SendAt:    sendAt,
+		FromEmail: sm.FromEmail,
+		To:        sm.To,
+		Subject:   sm.Subject,
+	}, nil
+}
+
+type mandrillListScheduledParams struct {
+	Key string `json:"key"`
+	To  string `json:"to,omitempty"`
+}
+
+type mandrillRescheduleParams struct {
+	Key    string `json:"key"`
+	Id     string `json:"id"`
+	SendAt string `json:"send_at"`
+}
+
+// ListScheduled returns the messages waiting to be sent, optionally only those to one address
+func (m *mandrill) ListScheduled(to string) ([]ScheduledMessage, error) {
+	return m.ListScheduledContext(context.Background(), to)
+}
+
+// ListScheduledContext is ListScheduled with a context that bounds the API call
+func (m *mandrill) ListScheduledContext(ctx context.Context, to string) ([]ScheduledMessage, error) {
+
+	params := &mandrillListScheduledParams{
+		Key: m.key,
+		To:  strings.TrimSpace(to),
+	}
+
+	var results []mandrillScheduledMessage
+	if err := m.call(ctx, MANDRILL_LIST_SCHEDULED_PATH, params, &results); err != nil {
+		return nil, err
+	}
+
+	scheduled := make([]ScheduledMessage, len(results), len(results))
+	for i := range results {
+		sm, err := results[i].scheduledMessage()
+		if err != nil {
+			return nil, err
+		}
+		scheduled[i] = *sm
+	}
+
+	return scheduled, nil
+}
+
+// CancelScheduled cancels a scheduled message so that it's never sent, and returns it
+func (m *mandrill) CancelScheduled(id string) (*ScheduledMessage, error) {
+	return m.CancelScheduledContext(context.Background(), id)
+}
+
+// CancelScheduledContext is CancelScheduled with a context that bounds the API call
+func (m *mandrill) CancelScheduledContext(ctx context.Context, id string) (*ScheduledMessage, error) {
+
+	if strings.TrimSpace(id) == `` {
+		return nil, errors.New("CancelScheduled: Must specify id;")
+	}
+
+	var result mandrillScheduledMessage
+	if err := m.call(ctx, MANDRILL_CANCEL_SCHEDULED_PATH, &mandrillIdParams{Key: m.key, Id: id}, &result); err != nil {
+		return nil, err
+	}
+
+	return result.scheduledMessage()
+}
+
+// Reschedule changes when a scheduled message will be sent, and returns it. A sendAt in the past sends the
+// message immediately.
+func (m *mandrill) Reschedule(id string, sendAt time.Time) (*ScheduledMessage, error) {
+	return m.RescheduleContext(context.Background(), id, sendAt)
+}
+
+// RescheduleContext is Reschedule with a context that bounds the API call
+func (m *mandrill) RescheduleContext(ctx context.Context, id string, sendAt time.Time) (*ScheduledMessage, error) {
+
+	if strings.TrimSpace(id) == `` {
+		return nil, errors.New("Reschedule: Must specify id;")
+	}
+
+	if sendAt.IsZero() {
+		return nil, errors.New("Reschedule: Must specify send time;")
+	}
+
+	params := &mandrillRescheduleParams{
+		Key:    m.key,
+		Id:     id,
+		SendAt: sendAt.UTC().Format(mandrillDateTimeFormat),
+	}
+
+	var result mandrillScheduledMessage
+	if err := m.call(ctx, MANDRILL_RESCHEDULE_PATH, params, &result); err != nil {
+		return nil, err
+	}
+
+	return result.scheduledMessage()
+}
@@ -0,0 +1,101 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMandrill_ScheduledMessages(t *testing.T) {
+
+	var (
+		paths  []string
+		params []map[string]interface{}
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&p)
+		paths = append(paths, r.URL.Path)
+		params = append(params, p)
+
+		scheduled := `{"_id":"abc123","created_at":"2024-03-01 09:00:00","send_at":"2024-03-02 09:00:00","from_email":"from@example.com","to":"to@example.com","subject":"Reminder"}`
+		switch r.URL.Path {
+		case MANDRILL_LIST_SCHEDULED_PATH:
+			w.Write([]byte(`[` + scheduled + `]`))
+		case MANDRILL_RESCHEDULE_PATH:
+			w.Write([]byte(`{"_id":"abc123","created_at":"2024-03-01 09:00:00","send_at":"2024-03-03 18:30:00","to":"to@example.com"}`))
+		case MANDRILL_CANCEL_SCHEDULED_PATH:
+			w.Write([]byte(scheduled))
+		}
+	})
+
+	list, err := m.ListScheduled(`to@example.com`)
+	if err != nil {
+		t.Fatalf("ListScheduled failed with error : %s", err.Error())
+	}
+	if len(list) != 1 || list[0].Id != `abc123` || list[0].Subject != `Reminder` {
+		t.Fatalf("Unexpected scheduled messages %+v", list)
+	}
+	if !list[0].SendAt.Equal(time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected send time %s", list[0].SendAt)
+	}
+	if params[0][`to`] != `to@example.com` {
+		t.Errorf("Expected the list to be filtered by recipient, got %v", params[0])
+	}
+
+	// send_at is always sent in UTC
+	sendAt := time.Date(2024, 3, 3, 20, 30, 0, 0, time.FixedZone(`UTC+2`, 2*60*60))
+	sm, err := m.Reschedule(`abc123`, sendAt)
+	if err != nil {
+		t.Fatalf("Reschedule failed with error : %s", err.Error())
+	}
+	if params[1][`id`] != `abc123` || params[1][`send_at`] != `2024-03-03 18:30:00` {
+		t.Errorf("Unexpected reschedule params %v", params[1])
+	}
+	if !sm.SendAt.Equal(sendAt) {
+		t.Errorf("Unexpected rescheduled time %s", sm.SendAt)
+	}
+
+	if _, err := m.CancelScheduled(`abc123`); err != nil {
+		t.Fatalf("CancelScheduled failed with error : %s", err.Error())
+	}
+	if paths[2] != MANDRILL_CANCEL_SCHEDULED_PATH || params[2][`id`] != `abc123` {
+		t.Errorf("Unexpected cancel request %s %v", paths[2], params[2])
+	}
+
+	if _, err := m.Reschedule(`abc123`, time.Time{}); err == nil {
+		t.Errorf("Expected an error for a missing send time")
+	}
+	if _, err := m.CancelScheduled(``); err == nil {
+		t.Errorf("Expected an error for a missing id")
+	}
+}
+
+func TestMandrill_CancelScheduledUnknown(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"status":"error","code":12,"name":"Unknown_Message","message":"No message exists with the id 'abc123'"}`))
+	})
+
+	_, err := m.CancelScheduled(`abc123`)
+	if !IsUnknownMessage(err) {
+		t.Errorf("Expected an unknown message error, got %v", err)
+	}
+}
+
+func TestMandrill_ListScheduledEmptyTimes(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"_id":"abc123","created_at":"","send_at":"2024-03-02 09:00:00","to":"to@example.com"}]`))
+	})
+
+	list, err := m.ListScheduled(``)
+	if err != nil {
+		t.Fatalf("ListScheduled failed with error : %s", err.Error())
+	}
+	if len(list) != 1 || !list[0].CreatedAt.IsZero() || list[0].SendAt.IsZero() {
+		t.Errorf("Expected an empty created_at to give the zero time, got %+v", list)
+	}
+}
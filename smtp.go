@@ -0,0 +1,486 @@
+package mandrillmail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"html/template"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// SMTPOption configures an optional setting on the SMTP mailer, in the same way as MandrillOption
+type SMTPOption func(s *smtpMailer) error
+
+// smtpMailer is a Mailer that delivers through an SMTP server, such as an on-premises relay. It builds the
+// MIME message itself, so Mandrill-only features (server-side merge tags, scheduling, tracking, tags and
+// metadata) aren't available.
+type smtpMailer struct {
+	addr          string
+	host          string
+	domain        string
+	defaultSender *MailRecipient
+	auth          smtp.Auth
+	tlsConfig     *tls.Config
+	requireTLS    bool
+	localName     string
+	timeout       time.Duration
+	logger        Logger
+
+	suppressions      SuppressionStore
+	suppressionPolicy SuppressionPolicy
+}
+
+var _ MailerContext = new(smtpMailer)
+
+// NewSMTPMailer creates a Mailer for the SMTP server at addr (host:port). domain is used for Message-IDs and
+// sender is the From address for TemplateMail. STARTTLS is used whenever the server offers it.
+func NewSMTPMailer(addr string, domain string, sender *MailRecipient, opts ...SMTPOption) (*smtpMailer, error) {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.New("addr must be host:port")
+	}
+
+	if len(domain) == 0 {
+		return nil, errors.New("domain is required")
+	}
+
+	if sender == nil {
+		return nil, errors.New("sender is required")
+	}
+
+	s := &smtpMailer{
+		addr:          addr,
+		host:          host,
+		domain:        domain,
+		defaultSender: sender,
+		logger:        nopLogger{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// WithSMTPAuth authenticates with auth, e.g. smtp.PlainAuth. Like net/smtp, PLAIN auth is refused over an
+// unencrypted connection except to localhost.
+func WithSMTPAuth(auth smtp.Auth) SMTPOption {
+	return func(s *smtpMailer) error {
+		if auth == nil {
+			return errors.New("WithSMTPAuth: auth must not be nil")
+		}
+		s.auth = auth
+		return nil
+	}
+}
+
+// WithSMTPTLSConfig sets the TLS configuration for STARTTLS, e.g. to trust a private CA. Its ServerName
+// defaults to the host from addr.
+func WithSMTPTLSConfig(config *tls.Config) SMTPOption {
+	return func(s *smtpMailer) error {
+		s.tlsConfig = config
+		return nil
+	}
+}
+
+// WithSMTPRequireTLS refuses to send unless the server supports STARTTLS
+func WithSMTPRequireTLS() SMTPOption {
+	return func(s *smtpMailer) error {
+		s.requireTLS = true
+		return nil
+	}
+}
+
+// WithSMTPLocalName sets the name sent with EHLO, instead of localhost
+func WithSMTPLocalName(name string) SMTPOption {
+	return func(s *smtpMailer) error {
+		if strings.TrimSpace(name) == `` {
+			return errors.New("WithSMTPLocalName: name must not be empty")
+		}
+		s.localName = name
+		return nil
+	}
+}
+
+// WithSMTPTimeout bounds each SMTP session, from connecting to QUIT
+func WithSMTPTimeout(d time.Duration) SMTPOption {
+	return func(s *smtpMailer) error {
+		if d <= 0 {
+			return errors.New("WithSMTPTimeout: timeout must be positive")
+		}
+		s.timeout = d
+		return nil
+	}
+}
+
+// WithSMTPLogger logs sessions and failures to logger, in the same way as WithLogger
+func WithSMTPLogger(logger Logger) SMTPOption {
+	return func(s *smtpMailer) error {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+// WithSMTPSuppressionStore checks the recipients of every send against store, in the same way as
+// WithSuppressionStore
+func WithSMTPSuppressionStore(store SuppressionStore, policy SuppressionPolicy) SMTPOption {
+	return func(s *smtpMailer) error {
+		if store == nil {
+			return errors.New("WithSMTPSuppressionStore: store must not be nil")
+		}
+		if policy != SUPPRESSION_DROP && policy != SUPPRESSION_FAIL {
+			return errors.New("WithSMTPSuppressionStore: unknown policy")
+		}
+		s.suppressions = store
+		s.suppressionPolicy = policy
+		return nil
+	}
+}
+
+// smtpEnvelope is one message to send in an SMTP session
+type smtpEnvelope struct {
+	from       string
+	recipients []MailRecipient
+	message    *mimeMessage
+}
+
+// SimpleMail sends a plain text message to a single recipient
+func (s *smtpMailer) SimpleMail(from, to, subject, body string) (*MailRecipientResponse, error) {
+	return s.SimpleMailContext(context.Background(), from, to, subject, body)
+}
+
+// SimpleMailContext is SimpleMail with a context that bounds the SMTP session
+func (s *smtpMailer) SimpleMailContext(ctx context.Context, from, to, subject, body string) (*MailRecipientResponse, error) {
+
+	from = strings.TrimSpace(from)
+	to = strings.TrimSpace(to)
+	subject = strings.TrimSpace(subject)
+
+	if from == `` {
+		return nil, errors.New("SimpleMail: Must specify source email address;")
+	}
+	if to == `` {
+		return nil, errors.New("SimpleMail: Must specify destination email address;")
+	}
+	if subject == `` {
+		return nil, errors.New("SimpleMail: Must specify subject;")
+	}
+
+	recipients := []MailRecipient{{Email: to, RecipientType: MAIL_TO}}
+
+	_, suppressed, err := s.checkSuppressions(recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(suppressed) > 0 {
+		return &suppressed[0], nil
+	}
+
+	id, err := newMessageId(s.domain)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &mimeMessage{
+		From:      &MailRecipient{Email: from},
+		Subject:   subject,
+		Text:      body,
+		MessageId: id,
+		Date:      time.Now(),
+	}
+	msg.setRecipients(recipients)
+
+	resp, err := s.deliver(ctx, []smtpEnvelope{{from: from, recipients: recipients, message: msg}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp[0], nil
+}
+
+// TemplateMail renders the template with vars and sends it to a single recipient from the default sender
+func (s *smtpMailer) TemplateMail(toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+	return s.TemplateMailContext(context.Background(), toEmail, subject, template, vars)
+}
+
+// TemplateMailContext is TemplateMail with a context that bounds the SMTP session
+func (s *smtpMailer) TemplateMailContext(ctx context.Context, toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+
+	if toEmail == `` {
+		return nil, errors.New("TemplateMail: Must specify destination email address;")
+	} else if template == nil {
+		return nil, errors.New("TemplateMail: Must specify template;")
+	}
+
+	message := &MailMessage{
+		HTMLTemplate: template,
+		TemplateVars: vars,
+		Subject:      subject,
+		From:         s.defaultSender,
+	}
+	recipients := []MailRecipient{{Email: toEmail, RecipientType: MAIL_TO}}
+
+	resp, err := s.BulkMailContext(ctx, recipients, message, new(SendParams))
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp[0], nil
+}
+
+// BulkMail sends message to all recipients in one SMTP session. With MERGE_MODE_LOCAL each recipient gets
+// their own rendering of the message; the server-side merge modes aren't supported.
+func (s *smtpMailer) BulkMail(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	return s.BulkMailContext(context.Background(), recipients, message, params)
+}
+
+// BulkMailContext is BulkMail with a context that bounds the SMTP session
+func (s *smtpMailer) BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	if len(recipients) == 0 {
+		return nil, errors.New("BulkMail: Must specify at least one recipient;")
+	}
+
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, err
+	}
+
+	if message.MergeMode == MERGE_MODE_MAILCHIMP || message.MergeMode == MERGE_MODE_HANDLEBARS {
+		return nil, errors.New("BulkMail: SMTP only supports MERGE_MODE_NONE and MERGE_MODE_LOCAL;")
+	}
+
+	if params != nil && params.SendAt != nil && !params.SendAt.IsZero() {
+		return nil, errors.New("BulkMail: SMTP doesn't support SendAt;")
+	}
+
+	send, suppressed, err := s.checkSuppressions(recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(send) == 0 {
+		return suppressed, nil
+	}
+
+	var envelopes []smtpEnvelope
+	if message.MergeMode == MERGE_MODE_LOCAL {
+		for i := range send {
+			msg, err := newMIMEMessage(s.domain, send[i:i+1], message, recipientTemplateVars(message, &send[i]))
+			if err != nil {
+				return nil, err
+			}
+			envelopes = append(envelopes, smtpEnvelope{from: message.From.Email, recipients: send[i : i+1], message: msg})
+		}
+	} else {
+		msg, err := newMIMEMessage(s.domain, send, message, message.TemplateVars)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, smtpEnvelope{from: message.From.Email, recipients: send, message: msg})
+	}
+
+	resp, err := s.deliver(ctx, envelopes)
+
+	return withDecided(recipients, send, resp, suppressed, err)
+}
+
+// checkSuppressions splits recipients into those to send and responses for those that are suppressed
+func (s *smtpMailer) checkSuppressions(recipients []MailRecipient) ([]MailRecipient, []MailRecipientResponse, error) {
+
+	send, suppressed, err := splitSuppressed(s.suppressions, s.suppressionPolicy, recipients)
+	if len(suppressed) > 0 {
+		s.logger.Debug(`smtp: suppressed recipients`, `suppressed`, len(suppressed))
+	}
+
+	return send, suppressed, err
+}
+
+// deliver sends each envelope as a transaction of one SMTP session, and returns a response for every
+// recipient in order. Recipients refused with a permanent (5xx) error are reported as rejected; any other
+// failure aborts the session. If earlier envelopes were already sent, the recipients of the rest are returned
+// in an *UnsentError.
+func (s *smtpMailer) deliver(ctx context.Context, envelopes []smtpEnvelope) ([]MailRecipientResponse, error) {
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	c, stop, err := s.dial(ctx)
+	if err != nil {
+		s.logger.Error(`smtp: connection failed`, `addr`, s.addr, `error`, err)
+		return nil, err
+	}
+	defer stop()
+	defer c.Close()
+
+	var resp []MailRecipientResponse
+	for i, env := range envelopes {
+
+		r, err := s.transaction(c, env)
+		if err != nil {
+			s.logger.Error(`smtp: send failed`, `addr`, s.addr, `error`, err)
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			var unsent []MailRecipient
+			for _, e := range envelopes[i:] {
+				unsent = append(unsent, e.recipients...)
+			}
+			return unsentAfter(resp, unsent, err)
+		}
+		resp = append(resp, r...)
+	}
+
+	if err := c.Quit(); err != nil {
+		s.logger.Debug(`smtp: quit failed`, `addr`, s.addr, `error`, err)
+	}
+
+	s.logger.Debug(`smtp: message sent`, `addr`, s.addr, `statuses`, summarizeStatuses(resp))
+
+	return resp, nil
+}
+
+// dial connects and prepares a session: EHLO, STARTTLS if offered, and AUTH if configured. Until stop is
+// called, the connection is closed if ctx is done, which aborts whatever the session is doing.
+func (s *smtpMailer) dial(ctx context.Context) (*smtp.Client, func() bool, error) {
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, `tcp`, s.addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	c, err := s.hello(conn)
+	if err != nil {
+		stop()
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+
+	return c, stop, nil
+}
+
+// hello runs the start of a session on conn
+func (s *smtpMailer) hello(conn net.Conn) (*smtp.Client, error) {
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.localName != `` {
+		if err := c.Hello(s.localName); err != nil {
+			return nil, err
+		}
+	}
+
+	if ok, _ := c.Extension(`STARTTLS`); ok {
+		config := &tls.Config{ServerName: s.host}
+		if s.tlsConfig != nil {
+			config = s.tlsConfig.Clone()
+			if config.ServerName == `` {
+				config.ServerName = s.host
+			}
+		}
+		if err := c.StartTLS(config); err != nil {
+			return nil, err
+		}
+	} else if s.requireTLS {
+		return nil, errors.New("smtp: server doesn't support STARTTLS")
+	}
+
+	if s.auth != nil {
+		if ok, _ := c.Extension(`AUTH`); !ok {
+			return nil, errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// transaction sends one envelope. If every recipient is refused, the message itself isn't sent.
+func (s *smtpMailer) transaction(c *smtp.Client, env smtpEnvelope) ([]MailRecipientResponse, error) {
+
+	data, err := env.message.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Mail(env.from); err != nil {
+		return nil, err
+	}
+
+	var (
+		resp     = make([]MailRecipientResponse, len(env.recipients), len(env.recipients))
+		accepted int
+	)
+	for i, r := range env.recipients {
+
+		resp[i] = MailRecipientResponse{
+			Id:     env.message.MessageId,
+			Email:  r.Email,
+			Status: MAIL_MESSAGE_SENT,
+		}
+
+		err := c.Rcpt(r.Email)
+		var smtpErr *textproto.Error
+		if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+			resp[i].Id = ``
+			resp[i].Status = MAIL_MESSAGE_REJECTED
+			resp[i].Error = smtpErr.Error()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		accepted++
+	}
+
+	if accepted == 0 {
+		return resp, c.Reset()
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
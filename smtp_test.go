@@ -0,0 +1,339 @@
+package mandrillmail
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjharr/mandrill-mail/mandrilltest"
+)
+
+func initSMTPMailer(t *testing.T, server *mandrilltest.SMTPServer, opts ...SMTPOption) *smtpMailer {
+
+	sender := &MailRecipient{Name: `SMTP Sender`, Email: `sender@example.com`}
+	opts = append([]SMTPOption{WithSMTPTLSConfig(server.ClientTLSConfig()), WithSMTPTimeout(5 * time.Second)}, opts...)
+
+	s, err := NewSMTPMailer(server.Addr, `example.com`, sender, opts...)
+	if err != nil {
+		t.Fatalf("NewSMTPMailer failed with error : %s", err.Error())
+	}
+
+	return s
+}
+
+func TestSMTPMailer_BulkMail(t *testing.T) {
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+	server.RequireAuth(`user`, `secret`)
+	server.RejectRecipient(`bounced@example.com`)
+
+	s := initSMTPMailer(t, server, WithSMTPAuth(smtp.PlainAuth(``, `user`, `secret`, `127.0.0.1`)), WithSMTPRequireTLS())
+
+	message := &MailMessage{
+		HTMLTemplate: template.Must(template.New(`smtp_html`).Parse(`<p>Hello {{.name}}</p><img src="cid:logo">`)),
+		TextTemplate: template.Must(template.New(`smtp_text`).Parse(`Hello {{.name}}`)),
+		TemplateVars: map[string]string{`name`: `World`},
+		Subject:      `Grüße`,
+		From:         &MailRecipient{Name: `From Name`, Email: `from@example.com`},
+		ReplyTo:      `reply@example.com`,
+		Attachments:  []EmailAttachment{{Name: `notes.txt`, MimeType: `text/plain`, Base64Content: `aGVsbG8=`}},
+		Images:       []EmailAttachment{{Name: `logo`, MimeType: `image/png`, Base64Content: `iVBORw0KGgo=`}},
+	}
+	recipients := []MailRecipient{
+		{Email: `to@example.com`, Name: `To Name`, RecipientType: MAIL_TO},
+		{Email: `cc@example.com`, RecipientType: MAIL_CC},
+		{Email: `bcc@example.com`, RecipientType: MAIL_BCC},
+		{Email: `bounced@example.com`, RecipientType: MAIL_TO},
+	}
+
+	resp, err := s.BulkMail(recipients, message, new(SendParams))
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if len(resp) != 4 || resp[0].Status != MAIL_MESSAGE_SENT || resp[0].Id == `` || resp[3].Status != MAIL_MESSAGE_REJECTED {
+		t.Fatalf("Unexpected responses %+v", resp)
+	}
+
+	msgs := server.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected one message, got %d", len(msgs))
+	}
+	if !msgs[0].TLS || msgs[0].Username != `user` || msgs[0].From != `from@example.com` {
+		t.Errorf("Unexpected session %+v", msgs[0])
+	}
+	if strings.Join(msgs[0].To, `,`) != `to@example.com,cc@example.com,bcc@example.com` {
+		t.Errorf("Unexpected envelope recipients %v", msgs[0].To)
+	}
+
+	parsed, err := msgs[0].Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse message : %s", err.Error())
+	}
+
+	h := parsed.Header
+	if h.Get(`To`) != `"To Name" <to@example.com>, <bounced@example.com>` || h.Get(`Cc`) != `<cc@example.com>` {
+		t.Errorf("Unexpected recipient headers %q, %q", h.Get(`To`), h.Get(`Cc`))
+	}
+	if h.Get(`Bcc`) != `` || strings.Contains(string(msgs[0].Data), `bcc@example.com`) {
+		t.Errorf("Expected Bcc recipients to be hidden")
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(h.Get(`Subject`)); subject != `Grüße` {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	if h.Get(`Reply-To`) != `reply@example.com` || h.Get(`Message-Id`) != `<`+resp[0].Id+`>` {
+		t.Errorf("Unexpected headers %v", h)
+	}
+
+	// mixed(related(alternative(text, html), image), attachment)
+	mixed := readMultipart(t, h.Get(`Content-Type`), parsed.Body, `multipart/mixed`, 2)
+	related := readMultipart(t, mixed[0].header.Get(`Content-Type`), strings.NewReader(mixed[0].body), `multipart/related`, 2)
+	alternative := readMultipart(t, related[0].header.Get(`Content-Type`), strings.NewReader(related[0].body), `multipart/alternative`, 2)
+
+	if alternative[0].body != `Hello World` || alternative[1].body != `<p>Hello World</p><img src="cid:logo">` {
+		t.Errorf("Unexpected content %q and %q", alternative[0].body, alternative[1].body)
+	}
+	if related[1].header.Get(`Content-Id`) != `<logo>` || !strings.HasPrefix(related[1].header.Get(`Content-Disposition`), `inline`) {
+		t.Errorf("Unexpected image headers %v", related[1].header)
+	}
+	if mixed[1].body != `hello` || !strings.HasPrefix(mixed[1].header.Get(`Content-Disposition`), `attachment`) {
+		t.Errorf("Unexpected attachment %q %v", mixed[1].body, mixed[1].header)
+	}
+}
+
+// testMIMEPart is a decoded part of a multipart body
+type testMIMEPart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+// readMultipart checks that body is multipart of the expected type with count parts, and returns the parts
+// with their content decoded
+func readMultipart(t *testing.T, contentType string, body io.Reader, want string, count int) []testMIMEPart {
+
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != want {
+		t.Fatalf("Expected %s, got %q", want, contentType)
+	}
+
+	var parts []testMIMEPart
+	r := multipart.NewReader(body, params[`boundary`])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to read %s part : %s", want, err.Error())
+		}
+
+		// quoted-printable is decoded by the reader, but base64 isn't
+		var content io.Reader = p
+		if p.Header.Get(`Content-Transfer-Encoding`) == `base64` {
+			content = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		b, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatalf("Failed to decode %s part : %s", want, err.Error())
+		}
+
+		parts = append(parts, testMIMEPart{header: p.Header, body: string(b)})
+	}
+
+	if len(parts) != count {
+		t.Fatalf("Expected %d parts in %s, got %d", count, want, len(parts))
+	}
+
+	return parts
+}
+
+func TestSMTPMailer_LocalMerge(t *testing.T) {
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+
+	s := initSMTPMailer(t, server)
+
+	message := &MailMessage{
+		TextTemplate: template.Must(template.New(`smtp_local`).Parse(`Hi {{.name}}`)),
+		Subject:      `Local Merge`,
+		From:         &MailRecipient{Email: `from@example.com`},
+		MergeMode:    MERGE_MODE_LOCAL,
+	}
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`name`: `A`}},
+		{Email: `b@example.com`, RecipientType: MAIL_BCC, MergeVars: map[string]string{`name`: `B`}},
+	}
+
+	resp, err := s.BulkMail(recipients, message, new(SendParams))
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+	if len(resp) != 2 || resp[0].Id == resp[1].Id {
+		t.Errorf("Expected a separate message per recipient, got %+v", resp)
+	}
+
+	msgs := server.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("Expected two messages, got %d", len(msgs))
+	}
+
+	second, _ := msgs[1].Parse()
+	body, _ := io.ReadAll(second.Body)
+	if second.Header.Get(`To`) != `undisclosed-recipients:;` || !strings.Contains(string(body), `Hi B`) {
+		t.Errorf("Unexpected message for Bcc recipient: %q %q", second.Header.Get(`To`), body)
+	}
+
+	message.MergeMode = MERGE_MODE_MAILCHIMP
+	if _, err := s.BulkMail(recipients, message, new(SendParams)); err == nil {
+		t.Errorf("Expected an error for a server-side merge mode")
+	}
+
+	sendAt := time.Now().Add(time.Hour)
+	message.MergeMode = MERGE_MODE_NONE
+	if _, err := s.BulkMail(recipients, message, &SendParams{SendAt: &sendAt}); err == nil {
+		t.Errorf("Expected an error for a scheduled send")
+	}
+}
+
+func TestSMTPMailer_PartialFailure(t *testing.T) {
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+	server.DeferRecipient(`b@example.com`)
+
+	s := initSMTPMailer(t, server)
+
+	message := newTestMessage()
+	message.MergeMode = MERGE_MODE_LOCAL
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+		{Email: `c@example.com`, RecipientType: MAIL_TO},
+	}
+
+	// the temporary error ends the session after a@ was sent, so b@ and c@ are left to retry
+	resp, err := s.BulkMail(recipients, message, new(SendParams))
+
+	var unsentErr *UnsentError
+	if !errors.As(err, &unsentErr) || len(unsentErr.Recipients) != 2 || unsentErr.Recipients[0].Email != `b@example.com` {
+		t.Fatalf("Expected an UnsentError for the last two recipients, got %v", err)
+	}
+
+	if len(resp) != 3 || resp[0].Status != MAIL_MESSAGE_SENT || resp[1].Status != MAIL_MESSAGE_UNKNOWN ||
+		resp[2].Status != MAIL_MESSAGE_UNKNOWN {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	if len(server.Messages()) != 1 {
+		t.Errorf("Expected one message to be delivered, got %d", len(server.Messages()))
+	}
+}
+
+func TestSMTPMailer_Suppression(t *testing.T) {
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+
+	store := NewMemorySuppressionStore(Suppression{Email: `donotemail@example.com`})
+	s := initSMTPMailer(t, server, WithSMTPSuppressionStore(store, SUPPRESSION_DROP))
+
+	message := newTestMessage()
+	recipients := []MailRecipient{
+		{Email: `donotemail@example.com`, RecipientType: MAIL_TO},
+		{Email: `to@example.com`, RecipientType: MAIL_TO},
+	}
+
+	resp, err := s.BulkMail(recipients, message, new(SendParams))
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+	if len(resp) != 2 || resp[0].RejectReason != MAIL_REJECT_SUPPRESSED || resp[1].Status != MAIL_MESSAGE_SENT {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	r, err := s.SimpleMail(`from@example.com`, `donotemail@example.com`, `Subject`, `Body`)
+	if err != nil || r.RejectReason != MAIL_REJECT_SUPPRESSED {
+		t.Errorf("Expected a suppressed SimpleMail response, got %+v, %v", r, err)
+	}
+
+	msgs := server.Messages()
+	if len(msgs) != 1 || len(msgs[0].To) != 1 || msgs[0].To[0] != `to@example.com` {
+		t.Errorf("Expected only the unsuppressed recipient to be sent, got %+v", msgs)
+	}
+
+	s = initSMTPMailer(t, server, WithSMTPSuppressionStore(store, SUPPRESSION_FAIL))
+	var supErr *SuppressionError
+	if _, err := s.BulkMail(recipients, message, new(SendParams)); !errors.As(err, &supErr) {
+		t.Errorf("Expected a SuppressionError, got %v", err)
+	}
+}
+
+func TestSMTPMailer_Failures(t *testing.T) {
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+	server.DisableSTARTTLS()
+
+	s := initSMTPMailer(t, server, WithSMTPRequireTLS())
+	if _, err := s.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`); err == nil {
+		t.Errorf("Expected an error when STARTTLS is required but not offered")
+	}
+
+	server.RequireAuth(`user`, `secret`)
+	s = initSMTPMailer(t, server, WithSMTPAuth(smtp.PlainAuth(``, `user`, `wrong`, `127.0.0.1`)))
+	if _, err := s.SimpleMail(`from@example.com`, `to@example.com`, `Subject`, `Body`); err == nil {
+		t.Errorf("Expected an error for bad credentials")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.SimpleMailContext(ctx, `from@example.com`, `to@example.com`, `Subject`, `Body`); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if len(server.Messages()) != 0 {
+		t.Errorf("Expected nothing to be delivered")
+	}
+
+	if _, err := NewSMTPMailer(`no-port`, `example.com`, &MailRecipient{Email: `a@example.com`}); err == nil {
+		t.Errorf("Expected an error for an address without a port")
+	}
+}
+
+func TestSMTPMailer_SimpleMail(t *testing.T) {
+
+	server := mandrilltest.NewSMTPServer()
+	defer server.Close()
+
+	s := initSMTPMailer(t, server)
+
+	resp, err := s.SimpleMail(`from@example.com`, `to@example.com`, `Simple`, "Line one\nLine two")
+	if err != nil {
+		t.Fatalf("SimpleMail failed with error : %s", err.Error())
+	}
+	if resp.Status != MAIL_MESSAGE_SENT || resp.Email != `to@example.com` {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	msgs := server.Messages()
+	if len(msgs) != 1 || !msgs[0].TLS {
+		t.Fatalf("Expected one message over TLS, got %+v", msgs)
+	}
+
+	parsed, _ := msgs[0].Parse()
+	if mt, _, _ := mime.ParseMediaType(parsed.Header.Get(`Content-Type`)); mt != `text/plain` {
+		t.Errorf("Expected a single text part, got %q", parsed.Header.Get(`Content-Type`))
+	}
+}
@@ -0,0 +1,301 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"strings"
+)
+
+// SplitBackend is one of the Mailers behind a SplitMailer
+type SplitBackend struct {
+	// Name identifies the backend in rules, MailRecipientResponse.Provider and errors
+	Name   string
+	Mailer Mailer
+	// Weight is the backend's share of recipients for messages that match no rule, relative to the other
+	// backends' weights. Zero sends it only the mail that rules give it.
+	Weight int
+}
+
+// SplitRule gives messages with any of Tags their own weights, e.g. to send marketing mail through a
+// different provider from transactional mail
+type SplitRule struct {
+	Tags []string
+	// Weights maps backend names to their share of recipients for matching messages. Backends that aren't
+	// listed get none.
+	Weights map[string]int
+}
+
+// SplitError is returned when some backends of a SplitMailer failed. The responses returned with it still
+// cover every recipient; those in Recipients have MAIL_MESSAGE_UNKNOWN status and their backend's error.
+type SplitError struct {
+	// Recipients were not sent, and can be retried
+	Recipients []MailRecipient
+	// Errors holds the error from each backend that failed
+	Errors []*ProviderError
+}
+
+func (e *SplitError) Error() string {
+
+	msgs := make([]string, len(e.Errors), len(e.Errors))
+	for i, pe := range e.Errors {
+		msgs[i] = pe.Error()
+	}
+
+	return fmt.Sprintf("Split: %d recipient(s) not sent: %s", len(e.Recipients), strings.Join(msgs, `; `))
+}
+
+// Unwrap exposes the backend errors to errors.Is and errors.As, e.g. to find an *APIError
+func (e *SplitError) Unwrap() []error {
+	return unwrapProviderErrors(e.Errors)
+}
+
+func (e *SplitError) unsent() []MailRecipient {
+	return e.Recipients
+}
+
+// SplitMailer is a Mailer that divides recipients between several backends by weight, e.g. to move traffic
+// to a new provider gradually. Routing is sticky: a recipient is always sent through the same backend, chosen
+// by a hash of their email address, for as long as the weights don't change. Raising one of two backends'
+// weight only moves recipients onto it. Rules can give messages with particular tags their own weights.
+//
+// Recipients of a BulkMail that go to different backends get separate messages, so they won't see each other
+// in To and Cc. A SplitMailer is safe for concurrent use.
+type SplitMailer struct {
+	backends []SplitBackend
+	weights  []int
+	rules    []splitRule
+}
+
+// splitRule is a SplitRule with its weights in the order of the backends
+type splitRule struct {
+	tags    map[string]bool
+	weights []int
+}
+
+var _ MailerContext = new(SplitMailer)
+
+// NewSplitMailer creates a SplitMailer. Rules are checked in order, and the first one with a tag of the
+// message applies. Messages that match no rule are split by the backends' own weights.
+func NewSplitMailer(backends []SplitBackend, rules ...SplitRule) (*SplitMailer, error) {
+
+	if len(backends) == 0 {
+		return nil, errors.New("at least one backend is required")
+	}
+
+	s := &SplitMailer{backends: append([]SplitBackend(nil), backends...)}
+
+	index := map[string]int{}
+	for i, b := range backends {
+		if b.Mailer == nil {
+			return nil, errors.New("backend " + b.Name + " has no Mailer")
+		}
+		if _, ok := index[b.Name]; ok {
+			return nil, errors.New("backend " + b.Name + " is listed twice")
+		}
+		if b.Weight < 0 {
+			return nil, errors.New("backend " + b.Name + " has a negative weight")
+		}
+		index[b.Name] = i
+		s.weights = append(s.weights, b.Weight)
+	}
+
+	if totalWeight(s.weights) == 0 {
+		return nil, errors.New("at least one backend must have a weight")
+	}
+
+	for _, r := range rules {
+
+		if len(r.Tags) == 0 {
+			return nil, errors.New("rules must have at least one tag")
+		}
+
+		sr := splitRule{tags: map[string]bool{}, weights: make([]int, len(backends), len(backends))}
+		for _, tag := range r.Tags {
+			sr.tags[tag] = true
+		}
+		for name, w := range r.Weights {
+			i, ok := index[name]
+			if !ok {
+				return nil, errors.New("rule refers to unknown backend " + name)
+			}
+			if w < 0 {
+				return nil, errors.New("rule has a negative weight for backend " + name)
+			}
+			sr.weights[i] = w
+		}
+		if totalWeight(sr.weights) == 0 {
+			return nil, fmt.Errorf("rule for tags %v must give at least one backend a weight", r.Tags)
+		}
+
+		s.rules = append(s.rules, sr)
+	}
+
+	return s, nil
+}
+
+// BulkMail sends message to recipients, each through the backend it is routed to. Responses are in the order
+// of recipients, whichever backend sent them.
+func (s *SplitMailer) BulkMail(recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	return s.BulkMailContext(context.Background(), recipients, message, params)
+}
+
+// BulkMailContext is BulkMail with a context that bounds the sends to every backend
+func (s *SplitMailer) BulkMailContext(ctx context.Context, recipients []MailRecipient, message *MailMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	// invalid input is refused before anything is sent, rather than failing some backends' groups
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if message == nil {
+		return nil, errors.New("BulkMail: Must specify message;")
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, err
+	}
+
+	if params == nil {
+		params = new(SendParams)
+	}
+
+	weights := s.weightsFor(message.Tags)
+	groups := make([][]MailRecipient, len(s.backends), len(s.backends))
+	for _, r := range recipients {
+		i := pickBackend(r.Email, weights)
+		groups[i] = append(groups[i], r)
+	}
+
+	var (
+		resp     []MailRecipientResponse
+		splitErr = new(SplitError)
+	)
+	for i, group := range groups {
+
+		if len(group) == 0 {
+			continue
+		}
+
+		b := s.backends[i]
+		r, err := bulkMailContext(ctx, b.Mailer, group, message, params)
+		if err == nil {
+			resp = append(resp, withProvider(r, b.Name)...)
+			continue
+		}
+
+		// anything but a partial failure fails the whole group, but the other groups may already be sent
+		sent, unsent := splitUnsent(r, group, err)
+		resp = append(resp, withProvider(sent, b.Name)...)
+		resp = append(resp, unsentResponses(unsent, err)...)
+
+		splitErr.Recipients = append(splitErr.Recipients, unsent...)
+		splitErr.Errors = append(splitErr.Errors, &ProviderError{Provider: b.Name, Err: err})
+	}
+
+	resp = inRecipientOrder(recipients, resp)
+	if len(splitErr.Errors) > 0 {
+		return resp, splitErr
+	}
+
+	return resp, nil
+}
+
+// TemplateMail sends a templated email through the backend toEmail is routed to
+func (s *SplitMailer) TemplateMail(toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+	return s.TemplateMailContext(context.Background(), toEmail, subject, template, vars)
+}
+
+// TemplateMailContext is TemplateMail with a context that bounds the send
+func (s *SplitMailer) TemplateMailContext(ctx context.Context, toEmail string, subject string, template *template.Template, vars map[string]string) (*MailRecipientResponse, error) {
+
+	b := s.backends[pickBackend(toEmail, s.weights)]
+
+	var (
+		r   *MailRecipientResponse
+		err error
+	)
+	if mc, ok := b.Mailer.(MailerContext); ok {
+		r, err = mc.TemplateMailContext(ctx, toEmail, subject, template, vars)
+	} else {
+		r, err = b.Mailer.TemplateMail(toEmail, subject, template, vars)
+	}
+
+	return withProviderSingle(r, b.Name), err
+}
+
+// SimpleMail sends a very simple email through the backend to is routed to
+func (s *SplitMailer) SimpleMail(from, to, subject, body string) (*MailRecipientResponse, error) {
+	return s.SimpleMailContext(context.Background(), from, to, subject, body)
+}
+
+// SimpleMailContext is SimpleMail with a context that bounds the send
+func (s *SplitMailer) SimpleMailContext(ctx context.Context, from, to, subject, body string) (*MailRecipientResponse, error) {
+
+	b := s.backends[pickBackend(to, s.weights)]
+
+	var (
+		r   *MailRecipientResponse
+		err error
+	)
+	if mc, ok := b.Mailer.(MailerContext); ok {
+		r, err = mc.SimpleMailContext(ctx, from, to, subject, body)
+	} else {
+		r, err = b.Mailer.SimpleMail(from, to, subject, body)
+	}
+
+	return withProviderSingle(r, b.Name), err
+}
+
+// Route returns the name of the backend that email is sent through for a message with tags
+func (s *SplitMailer) Route(email string, tags []string) string {
+	return s.backends[pickBackend(email, s.weightsFor(tags))].Name
+}
+
+// weightsFor returns the weights of the first rule matching tags, or the backends' own weights
+func (s *SplitMailer) weightsFor(tags []string) []int {
+
+	for _, r := range s.rules {
+		for _, tag := range tags {
+			if r.tags[tag] {
+				return r.weights
+			}
+		}
+	}
+
+	return s.weights
+}
+
+// pickBackend chooses a backend index for email in proportion to weights. The same email always hashes to the
+// same point, so it goes to the same backend while the weights are unchanged. The hash is scaled to the total
+// weight rather than taken modulo it, so each email keeps its relative position as the weights change: with
+// two backends, raising one's weight only moves emails onto it.
+func pickBackend(email string, weights []int) int {
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	point := int(uint64(h.Sum32()) * uint64(totalWeight(weights)) >> 32)
+
+	for i, w := range weights {
+		if point < w {
+			return i
+		}
+		point -= w
+	}
+
+	return len(weights) - 1
+}
+
+func totalWeight(weights []int) int {
+
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	return total
+}
@@ -0,0 +1,211 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func newSplitTestRecipients(n int) []MailRecipient {
+
+	recipients := make([]MailRecipient, n, n)
+	for i := range recipients {
+		recipients[i] = MailRecipient{Email: fmt.Sprintf("user%d@example.com", i), RecipientType: MAIL_TO}
+	}
+
+	return recipients
+}
+
+func TestSplitMailer_Weights(t *testing.T) {
+
+	current, next := NewFakeMailer(), NewFakeMailer()
+	s, err := NewSplitMailer([]SplitBackend{
+		{Name: `current`, Mailer: current, Weight: 75},
+		{Name: `next`, Mailer: next, Weight: 25},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := newSplitTestRecipients(1000)
+	resp, err := s.BulkMail(recipients, newTestMessage(), nil)
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if len(resp) != len(recipients) {
+		t.Fatalf("Expected %d responses, got %d", len(recipients), len(resp))
+	}
+
+	counts := map[string]int{}
+	for i, r := range resp {
+		if r.Email != recipients[i].Email {
+			t.Fatalf("Expected responses in recipient order, got %s at %d", r.Email, i)
+		}
+		counts[r.Provider]++
+		// routing is sticky
+		if r.Provider != s.Route(r.Email, nil) {
+			t.Fatalf("%s was sent through %s, but routes to %s", r.Email, r.Provider, s.Route(r.Email, nil))
+		}
+	}
+	if counts[`next`] < 200 || counts[`next`] > 300 || counts[`current`]+counts[`next`] != len(recipients) {
+		t.Errorf("Expected about a quarter of recipients through next, got %v", counts)
+	}
+	if len(next.Messages()) != 1 || len(next.Messages()[0].Recipients) != counts[`next`] {
+		t.Errorf("Expected one message through next for all of its recipients")
+	}
+
+	if r, err := s.SimpleMail(`from@example.com`, `USER7@example.com `, `Subject`, `Body`); err != nil || r.Provider != s.Route(`user7@example.com`, nil) {
+		t.Errorf("Expected SimpleMail to follow the same routing regardless of case, got %+v and %v", r, err)
+	}
+
+	// moving more traffic to next only moves recipients onto it
+	s2, _ := NewSplitMailer([]SplitBackend{
+		{Name: `current`, Mailer: current, Weight: 50},
+		{Name: `next`, Mailer: next, Weight: 50},
+	})
+	for _, r := range recipients {
+		if s.Route(r.Email, nil) == `next` && s2.Route(r.Email, nil) != `next` {
+			t.Fatalf("%s moved off next when its weight was raised", r.Email)
+		}
+	}
+}
+
+func TestSplitMailer_RaisingWeight(t *testing.T) {
+
+	recipients := newSplitTestRecipients(2000)
+
+	tests := []struct {
+		before, after [2]int
+		raised        string
+	}{
+		{[2]int{1, 1}, [2]int{1, 2}, `next`},
+		{[2]int{90, 10}, [2]int{90, 20}, `next`},
+		{[2]int{1, 1}, [2]int{2, 1}, `current`},
+		{[2]int{3, 7}, [2]int{1000, 7}, `current`},
+	}
+
+	for _, test := range tests {
+
+		split := func(weights [2]int) *SplitMailer {
+			s, err := NewSplitMailer([]SplitBackend{
+				{Name: `current`, Mailer: NewFakeMailer(), Weight: weights[0]},
+				{Name: `next`, Mailer: NewFakeMailer(), Weight: weights[1]},
+			})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			return s
+		}
+		before, after := split(test.before), split(test.after)
+
+		moved := 0
+		for _, r := range recipients {
+			was, is := before.Route(r.Email, nil), after.Route(r.Email, nil)
+			if was == test.raised && is != test.raised {
+				t.Fatalf("%s moved off %s when its weight went from %v to %v", r.Email, test.raised, test.before, test.after)
+			}
+			if was != is {
+				moved++
+			}
+		}
+		if moved == 0 {
+			t.Errorf("Expected some recipients to move onto %s when its weight went from %v to %v", test.raised, test.before, test.after)
+		}
+	}
+}
+
+func TestSplitMailer_Rules(t *testing.T) {
+
+	primary, ses := NewFakeMailer(), NewFakeMailer()
+	s, err := NewSplitMailer([]SplitBackend{
+		{Name: `mandrill`, Mailer: primary, Weight: 1},
+		{Name: `ses`, Mailer: ses},
+	}, SplitRule{Tags: []string{`marketing`, `newsletter`}, Weights: map[string]int{`ses`: 1}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	recipients := newSplitTestRecipients(20)
+
+	message := newTestMessage()
+	if _, err := s.BulkMail(recipients, message, nil); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	message.Tags = []string{`weekly`, `newsletter`}
+	if _, err := s.BulkMail(recipients, message, nil); err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if len(primary.Messages()) != 1 || len(primary.Messages()[0].Recipients) != 20 {
+		t.Errorf("Expected untagged mail to go through mandrill only")
+	}
+	if len(ses.Messages()) != 1 || len(ses.Messages()[0].Recipients) != 20 {
+		t.Errorf("Expected newsletter mail to go through ses only")
+	}
+
+	invalid := []struct {
+		backends []SplitBackend
+		rules    []SplitRule
+	}{
+		{nil, nil},
+		{[]SplitBackend{{Name: `a`, Mailer: primary}}, nil},
+		{[]SplitBackend{{Name: `a`, Mailer: primary, Weight: 1}, {Name: `a`, Mailer: ses}}, nil},
+		{[]SplitBackend{{Name: `a`, Mailer: primary, Weight: 1}}, []SplitRule{{Tags: []string{`x`}, Weights: map[string]int{`b`: 1}}}},
+		{[]SplitBackend{{Name: `a`, Mailer: primary, Weight: 1}}, []SplitRule{{Weights: map[string]int{`a`: 1}}}},
+	}
+	for i, c := range invalid {
+		if _, err := NewSplitMailer(c.backends, c.rules...); err == nil {
+			t.Errorf("Expected configuration %d to be refused", i)
+		}
+	}
+}
+
+func TestSplitMailer_PartialFailure(t *testing.T) {
+
+	ok, failing := NewFakeMailer(), NewFakeMailer()
+	failing.Err = errors.New(`connection refused`)
+
+	s, err := NewSplitMailer([]SplitBackend{
+		{Name: `ok`, Mailer: ok, Weight: 1},
+		{Name: `failing`, Mailer: failing, Weight: 1},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the queue retries only the recipients that weren't sent
+	q, _ := NewQueue(s, NewMemoryQueueStore(), QueueConfig{})
+	recipients := newSplitTestRecipients(20)
+	if _, err := q.Enqueue(recipients, newTestMessage(), nil); err != nil {
+		t.Fatalf("Enqueue failed : %s", err.Error())
+	}
+	q.ProcessReady(context.Background())
+
+	jobs, _ := q.store.List()
+	if len(jobs) != 1 {
+		t.Fatalf("Expected the job to be kept for a retry")
+	}
+	sent := ok.Messages()[0].Recipients
+	if len(sent) == 0 || len(jobs[0].Recipients)+len(sent) != len(recipients) {
+		t.Errorf("Expected %d recipients to be retried, got %d", len(recipients)-len(sent), len(jobs[0].Recipients))
+	}
+	for _, r := range jobs[0].Recipients {
+		if s.Route(r.Email, nil) != `failing` {
+			t.Errorf("%s was sent, but is queued for a retry", r.Email)
+		}
+	}
+
+	resp, err := s.BulkMail(recipients, newTestMessage(), nil)
+	var splitErr *SplitError
+	if !errors.As(err, &splitErr) || len(splitErr.Recipients) != len(jobs[0].Recipients) || len(resp) != len(recipients) {
+		t.Fatalf("Expected a SplitError, got %v", err)
+	}
+	for _, r := range resp {
+		if (r.Status == MAIL_MESSAGE_UNKNOWN) != (s.Route(r.Email, nil) == `failing`) {
+			t.Errorf("Unexpected response %+v", r)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SuppressionPolicy decides what a send does when some recipients are suppressed
+type SuppressionPolicy int
+
+const (
+	// SUPPRESSION_DROP sends to the other recipients and reports the suppressed ones as rejected
+	SUPPRESSION_DROP SuppressionPolicy = iota
+	// SUPPRESSION_FAIL refuses the whole send with a *SuppressionError
+	SUPPRESSION_FAIL
+)
+
+// Suppression is an address that must not be emailed, independent of Mandrill's reject list
+type Suppression struct {
+	Email   string    `json:"email"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+// SuppressionStore holds the local do-not-email list. Addresses are compared case insensitively.
+// Implementations must be safe for concurrent use.
+type SuppressionStore interface {
+	// Lookup returns the suppression for email, if there is one
+	Lookup(email string) (*Suppression, bool, error)
+	// Add suppresses an address, replacing any existing suppression for it
+	Add(s Suppression) error
+	// Remove lifts the suppression of an address, if there is one
+	Remove(email string) error
+	// List returns every suppression, sorted by address
+	List() ([]Suppression, error)
+}
+
+// SuppressionError is returned by a send under SUPPRESSION_FAIL. Responses explains, for each suppressed
+// recipient, why it was suppressed.
+type SuppressionError struct {
+	Responses []MailRecipientResponse
+}
+
+func (e *SuppressionError) Error() string {
+	return fmt.Sprintf("%d recipient(s) are suppressed;", len(e.Responses))
+}
+
+// WithSuppressionStore checks the recipients of every send against store before sending. Suppressed
+// recipients are handled according to policy; either way each gets a MAIL_MESSAGE_REJECTED response with
+// MAIL_REJECT_SUPPRESSED as the reason, and is never sent to Mandrill.
+func WithSuppressionStore(store SuppressionStore, policy SuppressionPolicy) MandrillOption {
+	return func(m *mandrill) error {
+		if store == nil {
+			return errors.New("WithSuppressionStore: store must not be nil")
+		}
+		if policy != SUPPRESSION_DROP && policy != SUPPRESSION_FAIL {
+			return errors.New("WithSuppressionStore: unknown policy")
+		}
+		m.suppressions = store
+		m.suppressionPolicy = policy
+		return nil
+	}
+}
+
+// checkSuppressions splits recipients into those to send and responses for those that are suppressed
+func (m *mandrill) checkSuppressions(recipients []MailRecipient) ([]MailRecipient, []MailRecipientResponse, error) {
+
+	send, suppressed, err := splitSuppressed(m.suppressions, m.suppressionPolicy, recipients)
+	if len(suppressed) > 0 {
+		m.logger.Debug(`mandrill: suppressed recipients`, `suppressed`, len(suppressed))
+	}
+
+	return send, suppressed, err
+}
+
+// checkSuppressed checks the single recipient of SimpleMail or TemplateMail. It returns the recipient's
+// response if they are suppressed under SUPPRESSION_DROP, so that the caller can return it without sending.
+func (m *mandrill) checkSuppressed(email string) (*MailRecipientResponse, error) {
+
+	_, suppressed, err := m.checkSuppressions([]MailRecipient{{Email: email, RecipientType: MAIL_TO}})
+	if err != nil || len(suppressed) == 0 {
+		return nil, err
+	}
+
+	return &suppressed[0], nil
+}
+
+// splitSuppressed splits recipients into those to send and responses for those that store suppresses. Under
+// SUPPRESSION_FAIL, any suppressed recipient fails the whole send instead. A nil store suppresses nobody.
+func splitSuppressed(store SuppressionStore, policy SuppressionPolicy, recipients []MailRecipient) ([]MailRecipient, []MailRecipientResponse, error) {
+
+	if store == nil {
+		return recipients, nil, nil
+	}
+
+	var (
+		send       = make([]MailRecipient, 0, len(recipients))
+		suppressed []MailRecipientResponse
+	)
+	for _, r := range recipients {
+
+		s, ok, err := store.Lookup(r.Email)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !ok {
+			send = append(send, r)
+			continue
+		}
+
+		reason := `suppressed`
+		if s.Reason != `` {
+			reason += `: ` + s.Reason
+		}
+		suppressed = append(suppressed, MailRecipientResponse{
+			Email:        r.Email,
+			Status:       MAIL_MESSAGE_REJECTED,
+			RejectReason: MAIL_REJECT_SUPPRESSED,
+			Error:        reason,
+		})
+	}
+
+	if len(suppressed) > 0 && policy == SUPPRESSION_FAIL {
+		return nil, nil, &SuppressionError{Responses: suppressed}
+	}
+
+	return send, suppressed, nil
+}
+
+// MemorySuppressionStore is a SuppressionStore that lives in memory
+type MemorySuppressionStore struct {
+	mu      sync.RWMutex
+	entries map[string]Suppression
+}
+
+var _ SuppressionStore = new(MemorySuppressionStore)
+
+// NewMemorySuppressionStore creates a MemorySuppressionStore holding suppressions
+func NewMemorySuppressionStore(suppressions ...Suppression) *MemorySuppressionStore {
+
+	s := &MemorySuppressionStore{
+		entries: make(map[string]Suppression, len(suppressions)),
+	}
+	for _, v := range suppressions {
+		s.entries[strings.ToLower(v.Email)] = v
+	}
+
+	return s
+}
+
+func (s *MemorySuppressionStore) Lookup(email string) (*Suppression, bool, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.entries[strings.ToLower(email)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return &v, true, nil
+}
+
+func (s *MemorySuppressionStore) Add(suppression Suppression) error {
+
+	if strings.TrimSpace(suppression.Email) == `` {
+		return errors.New("Suppression: Must specify email address;")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[strings.ToLower(suppression.Email)] = suppression
+	return nil
+}
+
+func (s *MemorySuppressionStore) Remove(email string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, strings.ToLower(email))
+	return nil
+}
+
+func (s *MemorySuppressionStore) List() ([]Suppression, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Suppression, 0, len(s.entries))
+	for _, v := range s.entries {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Email) < strings.ToLower(list[j].Email)
+	})
+
+	return list, nil
+}
+
+// suppressionLogEntry is one line of a FileSuppressionStore log. Add is set for additions and Remove for
+// removals.
+type suppressionLogEntry struct {
+	Add    *Suppression `json:"add,omitempty"`
+	Remove string       `json:"remove,omitempty"`
+}
+
+// FileSuppressionStore is a SuppressionStore backed by an append-only JSON lines file, in the same way as
+// FileQueueStore. Every change is synced before it returns. Call Compact periodically to drop superseded
+// entries.
+type FileSuppressionStore struct {
+	mu    sync.Mutex
+	log   *jsonLog
+	index *MemorySuppressionStore
+}
+
+var _ SuppressionStore = new(FileSuppressionStore)
+
+// OpenFileSuppressionStore opens, or creates, the store at path and loads its suppressions. A torn final
+// line, as left by a crash mid-write, is discarded.
+func OpenFileSuppressionStore(path string) (*FileSuppressionStore, error) {
+
+	s := &FileSuppressionStore{
+		index: NewMemorySuppressionStore(),
+	}
+
+	log, err := openJSONLog(path, `suppression store`, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+
+	return s, nil
+}
+
+// replay applies one line of the log to the index
+func (s *FileSuppressionStore) replay(line []byte) error {
+
+	var entry suppressionLogEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return err
+	}
+
+	if entry.Add != nil {
+		return s.index.Add(*entry.Add)
+	}
+
+	return s.index.Remove(entry.Remove)
+}
+
+func (s *FileSuppressionStore) Lookup(email string) (*Suppression, bool, error) {
+	return s.index.Lookup(email)
+}
+
+func (s *FileSuppressionStore) Add(suppression Suppression) error {
+
+	if strings.TrimSpace(suppression.Email) == `` {
+		return errors.New("Suppression: Must specify email address;")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.append(suppressionLogEntry{Add: &suppression}); err != nil {
+		return err
+	}
+
+	return s.index.Add(suppression)
+}
+
+func (s *FileSuppressionStore) Remove(email string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.append(suppressionLogEntry{Remove: email}); err != nil {
+		return err
+	}
+
+	return s.index.Remove(email)
+}
+
+func (s *FileSuppressionStore) List() ([]Suppression, error) {
+	return s.index.List()
+}
+
+// Compact rewrites the log with only the current suppressions, dropping those that were removed or added
+// again. A crash while compacting leaves either the old log or the new one intact.
+func (s *FileSuppressionStore) Compact() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suppressions, err := s.index.List()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]interface{}, len(suppressions))
+	for i := range suppressions {
+		entries[i] = suppressionLogEntry{Add: &suppressions[i]}
+	}
+
+	return s.log.rewrite(entries)
+}
+
+// Close closes the log file. The store can't be changed afterwards.
+func (s *FileSuppressionStore) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.close()
+}
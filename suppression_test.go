@@ -0,0 +1,301 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// suppressionTestHandler answers sends with a sent status for each recipient, failing any send to
+// fail@example.com, and records who was sent to
+func suppressionTestHandler(mu *sync.Mutex, sent *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p mandrillParams
+		json.NewDecoder(r.Body).Decode(&p)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		resp := make([]mandrillRecipientResponse, 0, len(p.Message.To))
+		for _, v := range p.Message.To {
+			if v.Email == `fail@example.com` {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":"error","code":-2,"name":"ValidationError","message":"bad"}`))
+				return
+			}
+			*sent = append(*sent, v.Email)
+			resp = append(resp, mandrillRecipientResponse{Email: v.Email, Status: MAIL_MESSAGE_SENT})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func TestMandrill_SuppressionDrop(t *testing.T) {
+
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	store := NewMemorySuppressionStore(Suppression{Email: `DoNotEmail@example.com`, Reason: `legal request`})
+	m := initLocalData(t, suppressionTestHandler(&mu, &sent), WithSuppressionStore(store, SUPPRESSION_DROP))
+
+	recipients := []MailRecipient{
+		{Email: `donotemail@example.com`, RecipientType: MAIL_TO},
+		{Email: `to@example.com`, RecipientType: MAIL_TO},
+	}
+
+	resp, err := m.BulkMail(recipients, newTestMessage(), new(SendParams))
+	if err != nil {
+		t.Fatalf("BulkMail failed with error : %s", err.Error())
+	}
+
+	if len(sent) != 1 || sent[0] != `to@example.com` {
+		t.Errorf("Expected the suppressed recipient to be dropped, sent %v", sent)
+	}
+	if len(resp) != 2 || resp[0].Status != MAIL_MESSAGE_REJECTED || resp[0].RejectReason != MAIL_REJECT_SUPPRESSED ||
+		resp[0].Error != `suppressed: legal request` || resp[1].Email != `to@example.com` {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+
+	// nothing is sent when every recipient is suppressed
+	resp, err = m.BulkMail(recipients[:1], newTestMessage(), new(SendParams))
+	if err != nil || len(resp) != 1 || len(sent) != 1 {
+		t.Errorf("Expected only a suppressed response, got %+v, %v", resp, err)
+	}
+
+	// a failed send still reports the suppressed recipient, and only the others are left to retry
+	resp, err = m.BulkMail([]MailRecipient{recipients[0], {Email: `fail@example.com`, RecipientType: MAIL_TO}}, newTestMessage(), new(SendParams))
+
+	var unsentErr *UnsentError
+	if !errors.As(err, &unsentErr) || len(unsentErr.Recipients) != 1 || unsentErr.Recipients[0].Email != `fail@example.com` {
+		t.Fatalf("Expected an UnsentError for the failed recipient, got %v", err)
+	}
+	if len(resp) != 2 || resp[0].RejectReason != MAIL_REJECT_SUPPRESSED || resp[1].Status != MAIL_MESSAGE_UNKNOWN {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+}
+
+func TestMandrill_SuppressionFail(t *testing.T) {
+
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	store := NewMemorySuppressionStore(Suppression{Email: `donotemail@example.com`})
+	m := initLocalData(t, suppressionTestHandler(&mu, &sent), WithSuppressionStore(store, SUPPRESSION_FAIL))
+
+	recipients := []MailRecipient{
+		{Email: `to@example.com`, RecipientType: MAIL_TO},
+		{Email: `donotemail@example.com`, RecipientType: MAIL_TO},
+	}
+
+	_, err := m.BulkMail(recipients, newTestMessage(), new(SendParams))
+
+	var supErr *SuppressionError
+	if !errors.As(err, &supErr) {
+		t.Fatalf("Expected a SuppressionError, got %v", err)
+	}
+	if len(supErr.Responses) != 1 || supErr.Responses[0].Email != `donotemail@example.com` || supErr.Responses[0].Error != `suppressed` {
+		t.Errorf("Unexpected suppressed responses %+v", supErr.Responses)
+	}
+	if len(sent) != 0 {
+		t.Errorf("Expected nothing to be sent, sent %v", sent)
+	}
+}
+
+func TestMandrill_SuppressionBatched(t *testing.T) {
+
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	store := NewMemorySuppressionStore(Suppression{Email: `donotemail@example.com`})
+	m := initLocalData(t, suppressionTestHandler(&mu, &sent),
+		WithSuppressionStore(store, SUPPRESSION_DROP), WithBatching(2, 1))
+
+	recipients := []MailRecipient{
+		{Email: `donotemail@example.com`, RecipientType: MAIL_TO},
+		{Email: `a@example.com`, RecipientType: MAIL_TO},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+		{Email: `fail@example.com`, RecipientType: MAIL_TO},
+	}
+
+	resp, err := m.BulkMail(recipients, newTestMessage(), new(SendParams))
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Chunks) != 1 {
+		t.Fatalf("Expected one failed chunk, got %v", err)
+	}
+
+	// the failed chunk's recipients account for the dropped recipient
+	failed := batchErr.Chunks[0].Recipients
+	if len(failed) != 1 || failed[0].Email != `fail@example.com` {
+		t.Errorf("Unexpected failed chunk recipients %+v", failed)
+	}
+	if len(resp) != 4 || resp[0].RejectReason != MAIL_REJECT_SUPPRESSED || resp[3].Status != MAIL_MESSAGE_UNKNOWN {
+		t.Errorf("Unexpected responses %+v", resp)
+	}
+}
+
+func TestMandrill_SuppressionSendPaths(t *testing.T) {
+
+	var (
+		mu   sync.Mutex
+		sent [][]string
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		var p struct {
+			To      []string `json:"to"`
+			Message struct {
+				To []struct {
+					Email string `json:"email"`
+				} `json:"to"`
+			} `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&p)
+
+		// raw sends list their recipients in to, and the others in the message
+		to := p.To
+		for _, v := range p.Message.To {
+			to = append(to, v.Email)
+		}
+
+		mu.Lock()
+		sent = append(sent, to)
+		mu.Unlock()
+
+		resp := make([]mandrillRecipientResponse, len(to))
+		for i, email := range to {
+			resp[i] = mandrillRecipientResponse{Email: email, Status: MAIL_MESSAGE_SENT}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}, WithSuppressionStore(NewMemorySuppressionStore(Suppression{Email: `customer@example.com`}), SUPPRESSION_DROP))
+
+	r, err := m.SimpleMail(`from@example.com`, `customer@example.com`, `Subject`, `Body`)
+	if err != nil || r.RejectReason != MAIL_REJECT_SUPPRESSED {
+		t.Errorf("Expected a suppressed SimpleMail response, got %+v, %v", r, err)
+	}
+
+	r, err = m.TemplateMail(`customer@example.com`, `Subject`, template.Must(template.New(`t`).Parse(`Hello`)), nil)
+	if err != nil || r.RejectReason != MAIL_REJECT_SUPPRESSED {
+		t.Errorf("Expected a suppressed TemplateMail response, got %+v, %v", r, err)
+	}
+
+	recipients := []MailRecipient{
+		{Email: `customer@example.com`, RecipientType: MAIL_TO},
+		{Email: `accounts@example.com`, RecipientType: MAIL_CC},
+	}
+	resp, err := m.SendTemplate(recipients, &TemplateMessage{TemplateName: `welcome`, Subject: `Welcome`}, nil)
+	if err != nil {
+		t.Fatalf("SendTemplate failed with error : %s", err.Error())
+	}
+	if len(resp) != 2 || resp[0].RejectReason != MAIL_REJECT_SUPPRESSED || resp[1].Status != MAIL_MESSAGE_SENT {
+		t.Errorf("Unexpected SendTemplate responses %+v", resp)
+	}
+
+	// the raw message's headers name the suppressed customer, so the other recipient is listed explicitly
+	resp, err = m.SendRaw([]byte(testRawMessage), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("SendRaw failed with error : %s", err.Error())
+	}
+	if len(resp) != 2 || resp[0].RejectReason != MAIL_REJECT_SUPPRESSED || resp[1].Email != `accounts@example.com` {
+		t.Errorf("Unexpected SendRaw responses %+v", resp)
+	}
+
+	if len(sent) != 2 || len(sent[0]) != 1 || sent[0][0] != `accounts@example.com` || len(sent[1]) != 1 || sent[1][0] != `accounts@example.com` {
+		t.Errorf("Expected only the unsuppressed recipient to be sent, sent %v", sent)
+	}
+}
+
+func TestFileSuppressionStore(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `suppressions.jsonl`)
+
+	store, err := OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSuppressionStore failed with error : %s", err.Error())
+	}
+
+	store.Add(Suppression{Email: `a@example.com`, Reason: `complaint`})
+	store.Add(Suppression{Email: `B@example.com`})
+	store.Remove(`a@example.com`)
+	if err := store.Add(Suppression{Email: ` `}); err == nil {
+		t.Errorf("Expected an error for a missing email")
+	}
+	store.Close()
+
+	// simulate a crash part way through writing an entry
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte(`{"add":{"email":"c@exa`))
+	f.Close()
+
+	store, err = OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("Reopening the store failed with error : %s", err.Error())
+	}
+	defer store.Close()
+
+	list, _ := store.List()
+	if len(list) != 1 || list[0].Email != `B@example.com` {
+		t.Fatalf("Unexpected suppressions after reopening %+v", list)
+	}
+
+	if _, ok, _ := store.Lookup(`b@EXAMPLE.com`); !ok {
+		t.Errorf("Expected lookups to ignore case")
+	}
+	if _, ok, _ := store.Lookup(`a@example.com`); ok {
+		t.Errorf("Expected the removed suppression to stay removed")
+	}
+
+	if err := store.Add(Suppression{Email: `c@example.com`}); err != nil {
+		t.Fatalf("Add after reopening failed with error : %s", err.Error())
+	}
+}
+
+func TestFileSuppressionStore_Compact(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `suppressions.jsonl`)
+
+	store, err := OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSuppressionStore failed with error : %s", err.Error())
+	}
+
+	store.Add(Suppression{Email: `a@example.com`})
+	store.Add(Suppression{Email: `b@example.com`, Reason: `bounce`})
+	store.Add(Suppression{Email: `b@example.com`, Reason: `complaint`})
+	store.Remove(`a@example.com`)
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact failed with error : %s", err.Error())
+	}
+	if err := store.Add(Suppression{Email: `c@example.com`}); err != nil {
+		t.Fatalf("Add after compacting failed with error : %s", err.Error())
+	}
+	store.Close()
+
+	if b, _ := os.ReadFile(path); strings.Count(string(b), "\n") != 2 {
+		t.Errorf("Expected the compacted log to hold 2 entries, got %q", b)
+	}
+
+	store, err = OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("Reopening the compacted store failed with error : %s", err.Error())
+	}
+	defer store.Close()
+
+	list, _ := store.List()
+	if len(list) != 2 || list[0].Email != `b@example.com` || list[0].Reason != `complaint` || list[1].Email != `c@example.com` {
+		t.Fatalf("Unexpected suppressions after reopening the compacted log %+v", list)
+	}
+
+	if err := store.Close(); err != nil || store.Compact() == nil {
+		t.Errorf("Expected Compact to fail once the store is closed")
+	}
+}
@@ -0,0 +1,207 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// @see https://mailchimp.com/developer/transactional/api/messages/send-using-message-template/
+
+// Merge languages understood by Mandrill templates
+const (
+	MERGE_LANGUAGE_MAILCHIMP  = `mailchimp`
+	MERGE_LANGUAGE_HANDLEBARS = `handlebars`
+)
+
+// TemplateMessage is a message whose content comes from a template stored in Mandrill, rather than from a
+// local html/template. Subject and From are optional and override the template's defaults when set.
+type TemplateMessage struct {
+	// TemplateName is the slug or name of the Mandrill template
+	TemplateName string
+	// TemplateContent fills the template's editable (mc:edit) regions, keyed by region name
+	TemplateContent map[string]string
+	// GlobalMergeVars are merge tag values shared by all recipients. Per-recipient values are set on
+	// MailRecipient.MergeVars.
+	GlobalMergeVars map[string]string
+	// MergeLanguage is MERGE_LANGUAGE_MAILCHIMP (Mandrill's default) or MERGE_LANGUAGE_HANDLEBARS
+	MergeLanguage string
+	Subject       string
+	From          *MailRecipient
+	ReplyTo       string
+	Attachments   []EmailAttachment
+	Images        []EmailAttachment
+	MarkImportant bool
+	Tags          []string
+	Metadata      map[string]string
+}
+
+func (tm *TemplateMessage) validate() error {
+
+	if strings.TrimSpace(tm.TemplateName) == `` {
+		return errors.New("Must set TemplateName for template message")
+	}
+
+	switch tm.MergeLanguage {
+	case ``, MERGE_LANGUAGE_MAILCHIMP, MERGE_LANGUAGE_HANDLEBARS:
+	default:
+		return errors.New("MergeLanguage must be mailchimp or handlebars")
+	}
+
+	return nil
+}
+
+type mandrillTemplateParams struct {
+	*mandrillParams
+	TemplateName    string             `json:"template_name"`
+	TemplateContent []mandrillMergeVar `json:"template_content"`
+}
+
+// SendTemplate sends a message rendered by Mandrill from one of its stored templates, with global and
+// per-recipient merge vars.
+func (m *mandrill) SendTemplate(recipients []MailRecipient, message *TemplateMessage, params *SendParams) ([]MailRecipientResponse, error) {
+	return m.SendTemplateContext(context.Background(), recipients, message, params)
+}
+
+// SendTemplateContext is SendTemplate with a context that bounds the API call
+func (m *mandrill) SendTemplateContext(ctx context.Context, recipients []MailRecipient, message *TemplateMessage, params *SendParams) ([]MailRecipientResponse, error) {
+
+	if message == nil {
+		return nil, errors.New("SendTemplate: Must specify message;")
+	}
+
+	if params == nil {
+		params = new(SendParams)
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("SendTemplate: Must specify at least one recipient;")
+	}
+
+	for _, v := range recipients {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := message.validate(); err != nil {
+		return nil, err
+	}
+
+	send, suppressed, err := m.checkSuppressions(recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(send) == 0 {
+		return suppressed, nil
+	}
+
+	msg, err := m.buildTemplateMessage(send, message)
+	if err != nil {
+		return nil, err
+	}
+	msg.TrackOpens = params.TrackOpens
+	msg.TrackClicks = params.TrackClicks
+
+	mandrillParams := m.buildParams(params, msg)
+	if err := mandrillParams.validate(); err != nil {
+		return nil, err
+	}
+
+	templateParams := &mandrillTemplateParams{
+		mandrillParams:  mandrillParams,
+		TemplateName:    message.TemplateName,
+		TemplateContent: buildTemplateContent(message.TemplateContent),
+	}
+
+	return m.idempotent(ctx, params, func() ([]MailRecipientResponse, error) {
+		resp, err := m.deliver(ctx, MANDRILL_TEMPLATE_PATH, templateParams, mandrillParams)
+		return withDecided(recipients, send, resp, suppressed, err)
+	})
+}
+
+// buildTemplateMessage builds a Mandrill-formatted message for a stored template. The content is left
+// empty, since Mandrill renders it.
+func (m *mandrill) buildTemplateMessage(recipients []MailRecipient, message *TemplateMessage) (*mandrillMessage, error) {
+
+	msg := m.defaultMessage()
+
+	msg.Subject = message.Subject
+	m.setMessageSender(message.From, message.ReplyTo, msg)
+
+	msg.MarkImportant = message.MarkImportant
+	msg.Metadata = message.Metadata
+	msg.Tags = message.Tags
+
+	msg.Merge = true
+	msg.MergeLanguage = message.MergeLanguage
+	msg.GlobalMergeVars = buildMergeVars(message.GlobalMergeVars)
+	msg.MergeVars = buildRecipientMergeVars(recipients)
+
+	if err := m.setMessageRecipients(recipients, nil, msg); err != nil {
+		return nil, err
+	}
+
+	m.setMessageAttachments(message.Attachments, message.Images, msg)
+
+	return msg, nil
+}
+
+// buildTemplateContent converts editable region content to Mandrill's format. Mandrill requires the field
+// even when it's empty, so this never returns nil.
+func buildTemplateContent(content map[string]string) []mandrillMergeVar {
+
+	if tc := buildMergeVars(content); tc != nil {
+		return tc
+	}
+
+	return []mandrillMergeVar{}
+}
+
+// buildMergeVars converts a map of merge vars to Mandrill's format, sorted by name
+func buildMergeVars(vars map[string]string) []mandrillMergeVar {
+
+	if len(vars) == 0 {
+		return nil
+	}
+
+	names := sortedKeys(vars)
+	mv := make([]mandrillMergeVar, len(names), len(names))
+	for i, name := range names {
+		mv[i] = mandrillMergeVar{
+			Name:    name,
+			Content: vars[name],
+		}
+	}
+
+	return mv
+}
+
+// buildRecipientMergeVars collects the merge vars of every recipient that has them
+func buildRecipientMergeVars(recipients []MailRecipient) []mandrillRecipientMergeVar {
+
+	var rmv []mandrillRecipientMergeVar
+	for _, v := range recipients {
+		if len(v.MergeVars) > 0 {
+			rmv = append(rmv, mandrillRecipientMergeVar{
+				Rcpt: v.Email,
+				Vars: buildMergeVars(v.MergeVars),
+			})
+		}
+	}
+
+	return rmv
+}
+
+// sortedKeys returns the keys of m in sorted order, so that requests are deterministic
+func sortedKeys(m map[string]string) []string {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
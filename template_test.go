@@ -0,0 +1,75 @@
+package mandrillmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestMandrill_SendTemplate(t *testing.T) {
+
+	var (
+		path string
+		body map[string]interface{}
+	)
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`[{"email":"a@example.com","status":"sent","_id":"1"},{"email":"b@example.com","status":"queued","_id":"2"}]`))
+	})
+
+	recipients := []MailRecipient{
+		{Email: `a@example.com`, RecipientType: MAIL_TO, MergeVars: map[string]string{`FNAME`: `Ann`}},
+		{Email: `b@example.com`, RecipientType: MAIL_TO},
+	}
+	message := &TemplateMessage{
+		TemplateName:    `welcome`,
+		TemplateContent: map[string]string{`header`: `<h1>Hi</h1>`},
+		GlobalMergeVars: map[string]string{`COMPANY`: `Acme`, `FNAME`: `friend`},
+	}
+
+	resp, err := m.SendTemplate(recipients, message, nil)
+	if err != nil {
+		t.Fatalf("SendTemplate failed with error : %s", err.Error())
+	}
+
+	if path != MANDRILL_TEMPLATE_PATH || len(resp) != 2 || resp[1].Status != MAIL_MESSAGE_QUEUED {
+		t.Errorf("Unexpected request to %s or responses %+v", path, resp)
+	}
+
+	if body[`template_name`] != `welcome` || body[`key`] != `local-test-key` {
+		t.Errorf("Unexpected template params : %+v", body)
+	}
+
+	msg := body[`message`].(map[string]interface{})
+	if _, ok := msg[`subject`]; ok {
+		t.Error("An empty subject should be omitted so the template default is used")
+	}
+
+	global := msg[`global_merge_vars`].([]interface{})
+	perRcpt := msg[`merge_vars`].([]interface{})
+	if len(global) != 2 || len(perRcpt) != 1 || msg[`merge`] != true {
+		t.Errorf("Unexpected merge vars : %+v %+v", global, perRcpt)
+	}
+
+	if perRcpt[0].(map[string]interface{})[`rcpt`] != `a@example.com` {
+		t.Errorf("Merge vars sent for the wrong recipient : %+v", perRcpt[0])
+	}
+}
+
+func TestMandrill_SendTemplateValidation(t *testing.T) {
+
+	m := initLocalData(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Invalid template messages should not be sent")
+	})
+
+	recipients := []MailRecipient{{Email: `a@example.com`, RecipientType: MAIL_TO}}
+
+	if _, err := m.SendTemplate(recipients, &TemplateMessage{}, nil); err == nil {
+		t.Error("Expected an error for a missing template name")
+	}
+
+	if _, err := m.SendTemplate(recipients, &TemplateMessage{TemplateName: `x`, MergeLanguage: `jinja`}, nil); err == nil {
+		t.Error("Expected an error for an unknown merge language")
+	}
+}
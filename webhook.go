@@ -0,0 +1,282 @@
+package mandrillmail
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// @see https://mailchimp.com/developer/transactional/guides/track-respond-activity-webhooks/
+
+const (
+	WEBHOOK_EVENTS_FIELD     = `mandrill_events`
+	WEBHOOK_SIGNATURE_HEADER = `X-Mandrill-Signature`
+)
+
+// WebhookEventType is the kind of a webhook event
+type WebhookEventType string
+
+const (
+	WEBHOOK_EVENT_SEND        WebhookEventType = `send`
+	WEBHOOK_EVENT_DEFERRAL    WebhookEventType = `deferral`
+	WEBHOOK_EVENT_HARD_BOUNCE WebhookEventType = `hard_bounce`
+	WEBHOOK_EVENT_SOFT_BOUNCE WebhookEventType = `soft_bounce`
+	WEBHOOK_EVENT_OPEN        WebhookEventType = `open`
+	WEBHOOK_EVENT_CLICK       WebhookEventType = `click`
+	WEBHOOK_EVENT_SPAM        WebhookEventType = `spam`
+	WEBHOOK_EVENT_UNSUB       WebhookEventType = `unsub`
+	WEBHOOK_EVENT_REJECT      WebhookEventType = `reject`
+)
+
+// WebhookEvent is a message event posted by Mandrill. Msg carries the Tags and Metadata that were set on
+// the MailMessage and MailRecipient when it was sent.
+type WebhookEvent struct {
+	Event WebhookEventType `json:"event"`
+	Id    string           `json:"_id"`
+	Ts    int64            `json:"ts"`
+	Msg   WebhookMessage   `json:"msg"`
+	// URL is the link that was clicked, for click events
+	URL string `json:"url"`
+	// IP, UserAgent and Location describe the client, for open and click events
+	IP        string           `json:"ip"`
+	UserAgent string           `json:"user_agent"`
+	Location  *WebhookLocation `json:"location"`
+	// Raw is the undecoded event, for fields not covered above
+	Raw json.RawMessage `json:"-"`
+}
+
+// Time returns when the event happened
+func (e *WebhookEvent) Time() time.Time {
+	return time.Unix(e.Ts, 0)
+}
+
+// WebhookMessage is the state of the message an event is about
+type WebhookMessage struct {
+	Id                string             `json:"_id"`
+	Ts                int64              `json:"ts"`
+	Email             string             `json:"email"`
+	Sender            string             `json:"sender"`
+	Subject           string             `json:"subject"`
+	State             string             `json:"state"`
+	Tags              []string           `json:"tags"`
+	Metadata          map[string]string  `json:"metadata"`
+	Subaccount        string             `json:"subaccount"`
+	BounceDescription string             `json:"bounce_description"`
+	Diag              string             `json:"diag"`
+	Opens             []WebhookOpen      `json:"opens"`
+	Clicks            []WebhookClick     `json:"clicks"`
+	SmtpEvents        []WebhookSmtpEvent `json:"smtp_events"`
+}
+
+type WebhookOpen struct {
+	Ts        int64            `json:"ts"`
+	IP        string           `json:"ip"`
+	UserAgent string           `json:"ua"`
+	Location  *WebhookLocation `json:"location"`
+}
+
+type WebhookClick struct {
+	Ts  int64  `json:"ts"`
+	URL string `json:"url"`
+}
+
+type WebhookSmtpEvent struct {
+	Ts            int64  `json:"ts"`
+	Type          string `json:"type"`
+	Diag          string `json:"diag"`
+	SourceIP      string `json:"source_ip"`
+	DestinationIP string `json:"destination_ip"`
+	Size          int    `json:"size"`
+}
+
+type WebhookLocation struct {
+	CountryShort string  `json:"country_short"`
+	Country      string  `json:"country"`
+	Region       string  `json:"region"`
+	City         string  `json:"city"`
+	PostalCode   string  `json:"postal_code"`
+	Timezone     string  `json:"timezone"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+}
+
+// WebhookCallback handles a webhook event. Returning an error makes the handler answer with a 500, so that
+// Mandrill retries the whole batch later; callbacks should therefore be idempotent.
+type WebhookCallback func(ctx context.Context, event *WebhookEvent) error
+
+// WebhookHandler is an http.Handler for Mandrill webhooks. It verifies each POST's signature, decodes its
+// events, and dispatches them to the callbacks registered for their type.
+type WebhookHandler struct {
+	key string
+	url string
+
+	mu        sync.RWMutex
+	callbacks map[WebhookEventType][]WebhookCallback
+	any       []WebhookCallback
+	routes    []inboundRoute
+}
+
+// NewWebhookHandler creates a WebhookHandler. key is the webhook's authentication key and url must be
+// exactly the URL the webhook is registered with in Mandrill, since both are part of the signature.
+func NewWebhookHandler(key, url string) (*WebhookHandler, error) {
+
+	if key == `` {
+		return nil, errors.New("webhook key is required")
+	}
+
+	if url == `` {
+		return nil, errors.New("webhook url is required")
+	}
+
+	return &WebhookHandler{
+		key:       key,
+		url:       url,
+		callbacks: map[WebhookEventType][]WebhookCallback{},
+	}, nil
+}
+
+// On registers a callback for one type of event. Callbacks run in the order they were registered.
+func (h *WebhookHandler) On(event WebhookEventType, callback WebhookCallback) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.callbacks[event] = append(h.callbacks[event], callback)
+}
+
+// OnAny registers a callback for every event, after any callbacks for the specific type
+func (h *WebhookHandler) OnAny(callback WebhookCallback) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.any = append(h.any, callback)
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// Mandrill checks that the URL exists with a HEAD request when the webhook is added
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set(`Allow`, `HEAD, POST`)
+		http.Error(w, `method not allowed`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `invalid form`, http.StatusBadRequest)
+		return
+	}
+
+	if !VerifyWebhookSignature(h.key, h.url, r.PostForm, r.Header.Get(WEBHOOK_SIGNATURE_HEADER)) {
+		http.Error(w, `invalid signature`, http.StatusForbidden)
+		return
+	}
+
+	events, err := ParseWebhookEvents(r.PostForm.Get(WEBHOOK_EVENTS_FIELD))
+	if err != nil {
+		http.Error(w, `invalid events`, http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		if err := h.dispatch(r.Context(), event); err != nil {
+			http.Error(w, `event handling failed`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// dispatch runs the callbacks for an event, stopping at the first error. Inbound messages are routed
+// between the callbacks for their type and those for any event.
+func (h *WebhookHandler) dispatch(ctx context.Context, event *WebhookEvent) error {
+
+	h.mu.RLock()
+	callbacks := append([]WebhookCallback(nil), h.callbacks[event.Event]...)
+	any := append([]WebhookCallback(nil), h.any...)
+	h.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		if err := callback(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	if event.Event == WEBHOOK_EVENT_INBOUND {
+		if err := h.route(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	for _, callback := range any {
+		if err := callback(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseWebhookEvents decodes the JSON array posted in the mandrill_events form field
+func ParseWebhookEvents(data string) ([]*WebhookEvent, error) {
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+
+	events := make([]*WebhookEvent, len(raw), len(raw))
+	for i := range raw {
+		event := new(WebhookEvent)
+		if err := json.Unmarshal(raw[i], event); err != nil {
+			return nil, err
+		}
+		event.Raw = raw[i]
+		events[i] = event
+	}
+
+	return events, nil
+}
+
+// WebhookSignature computes the X-Mandrill-Signature of a webhook POST: the base64 HMAC-SHA1, keyed by the
+// webhook key, of the URL followed by each form field's name and value, sorted by name.
+func WebhookSignature(key, url string, form url.Values) string {
+
+	names := make([]string, 0, len(form))
+	for name := range form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(url))
+	for _, name := range names {
+		for _, value := range form[name] {
+			mac.Write([]byte(name))
+			mac.Write([]byte(value))
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature is valid for the webhook POST
+func VerifyWebhookSignature(key, url string, form url.Values, signature string) bool {
+
+	expected := WebhookSignature(key, url, form)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,146 @@
+package mandrillmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testWebhookKey = `webhook-key`
+	testWebhookURL = `https://example.com/webhooks/mandrill`
+)
+
+// postWebhook posts events to h signed with signature, or with a valid signature if signature is empty
+func postWebhook(h http.Handler, events string, signature string) *httptest.ResponseRecorder {
+
+	form := url.Values{WEBHOOK_EVENTS_FIELD: {events}}
+	if signature == `` {
+		signature = WebhookSignature(testWebhookKey, testWebhookURL, form)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, `/webhooks/mandrill`, strings.NewReader(form.Encode()))
+	r.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+	r.Header.Set(WEBHOOK_SIGNATURE_HEADER, signature)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestWebhookSignature(t *testing.T) {
+
+	// base64(HMAC-SHA1("key", "http://example.com/hook" + "a1" + "b2"))
+	form := url.Values{`b`: {`2`}, `a`: {`1`}}
+	if sig := WebhookSignature(`key`, `http://example.com/hook`, form); sig != `B/6UttTXoywNzXs8/AuDXtmHUu0=` {
+		t.Fatalf("Unexpected signature %s", sig)
+	}
+}
+
+func TestWebhookHandler_Dispatch(t *testing.T) {
+
+	h, err := NewWebhookHandler(testWebhookKey, testWebhookURL)
+	if err != nil {
+		t.Fatalf("NewWebhookHandler failed with error : %s", err.Error())
+	}
+
+	var (
+		bounces []*WebhookEvent
+		clicks  []*WebhookEvent
+		all     int
+	)
+	h.On(WEBHOOK_EVENT_HARD_BOUNCE, func(ctx context.Context, event *WebhookEvent) error {
+		bounces = append(bounces, event)
+		return nil
+	})
+	h.On(WEBHOOK_EVENT_CLICK, func(ctx context.Context, event *WebhookEvent) error {
+		clicks = append(clicks, event)
+		return nil
+	})
+	h.OnAny(func(ctx context.Context, event *WebhookEvent) error {
+		all++
+		return nil
+	})
+
+	events := `[
+		{"event":"hard_bounce","_id":"abc","ts":1700000000,"msg":{"_id":"abc","email":"to@example.com","state":"bounced","bounce_description":"bad_mailbox","tags":["welcome"],"metadata":{"user":"42"}}},
+		{"event":"click","_id":"def","ts":1700000100,"url":"https://example.com/confirm","ip":"127.0.0.1","msg":{"_id":"def","email":"other@example.com","clicks":[{"ts":1700000100,"url":"https://example.com/confirm"}]}},
+		{"event":"open","_id":"ghi","ts":1700000200,"msg":{"_id":"ghi","email":"other@example.com"}}
+	]`
+
+	if w := postWebhook(h, events, ``); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	if len(bounces) != 1 || len(clicks) != 1 || all != 3 {
+		t.Fatalf("Unexpected dispatch: %d bounces, %d clicks, %d total", len(bounces), len(clicks), all)
+	}
+
+	bounce := bounces[0]
+	if bounce.Msg.Email != `to@example.com` || bounce.Msg.BounceDescription != `bad_mailbox` {
+		t.Errorf("Unexpected bounce message %+v", bounce.Msg)
+	}
+	if bounce.Msg.Metadata[`user`] != `42` || len(bounce.Msg.Tags) != 1 || bounce.Msg.Tags[0] != `welcome` {
+		t.Errorf("Expected metadata and tags on bounce, got %v and %v", bounce.Msg.Metadata, bounce.Msg.Tags)
+	}
+	if bounce.Time().Unix() != 1700000000 || len(bounce.Raw) == 0 {
+		t.Errorf("Unexpected bounce time or raw event")
+	}
+
+	if clicks[0].URL != `https://example.com/confirm` || len(clicks[0].Msg.Clicks) != 1 {
+		t.Errorf("Unexpected click event %+v", clicks[0])
+	}
+}
+
+func TestWebhookHandler_Rejects(t *testing.T) {
+
+	h, _ := NewWebhookHandler(testWebhookKey, testWebhookURL)
+
+	called := false
+	h.OnAny(func(ctx context.Context, event *WebhookEvent) error {
+		called = true
+		return nil
+	})
+
+	events := `[{"event":"send","msg":{"email":"to@example.com"}}]`
+
+	if w := postWebhook(h, events, `not-the-signature`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a bad signature, got %d", w.Code)
+	}
+
+	if w := postWebhook(h, `not json`, ``); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad events, got %d", w.Code)
+	}
+
+	if called {
+		t.Errorf("Expected no callbacks for rejected posts")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, `/webhooks/mandrill`, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for HEAD, got %d", w.Code)
+	}
+
+	if _, err := NewWebhookHandler(``, testWebhookURL); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+}
+
+func TestWebhookHandler_CallbackError(t *testing.T) {
+
+	h, _ := NewWebhookHandler(testWebhookKey, testWebhookURL)
+	h.On(WEBHOOK_EVENT_SEND, func(ctx context.Context, event *WebhookEvent) error {
+		return errors.New("database unavailable")
+	})
+
+	// a 500 makes Mandrill retry the batch
+	if w := postWebhook(h, `[{"event":"send","msg":{"email":"to@example.com"}}]`, ``); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when a callback fails, got %d", w.Code)
+	}
+}